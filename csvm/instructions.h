@@ -0,0 +1,107 @@
+// Code generated by stop/instructions/gen; DO NOT EDIT.
+
+#ifndef STOP_INSTRUCTIONS_H
+#define STOP_INSTRUCTIONS_H
+
+#define IHeaderHlt               0x00 // Halt
+#define IHeaderDbg               0x01 // Debug
+#define IHeaderLimits            0x02 // Limits
+#define IHeaderMovLiteral        0x08 // Move value
+#define IHeaderMovRegister       0x09 // Move register
+#define IHeaderPush              0x10 // Push value
+#define IHeaderDup               0x11 // Duplicate value
+#define IHeaderDrop              0x12 // Drop value
+#define IHeaderSwap              0x13 // Swap values
+#define IHeaderLd                0x20 // Load value
+#define IHeaderSt                0x21 // Store value
+#define IHeaderAdd               0x30 // Add
+#define IHeaderSub               0x31 // Subtract
+#define IHeaderMul               0x32 // Multiply
+#define IHeaderDiv               0x33 // Divide
+#define IHeaderMod               0x34 // Modulo
+#define IHeaderLabel             0xA0 // Label
+#define IHeaderCall              0xA1 // Call
+#define IHeaderJmp               0xA2 // Jump
+#define IHeaderJmpZ              0xA3 // Jump if zero
+#define IHeaderJmpNZ             0xA4 // Jump if not zero
+#define IHeaderJmpP              0xA5 // Jump if positive
+#define IHeaderJmpN              0xA6 // Jump if negative
+#define IHeaderRet               0xA7 // Return
+#define IHeaderCallAddr          0xC1 // Call address
+#define IHeaderJmpAddr           0xC2 // Jump to address
+#define IHeaderJmpZAddr          0xC3 // Jump to address if zero
+#define IHeaderJmpNZAddr         0xC4 // Jump to address if not zero
+#define IHeaderJmpPAddr          0xC5 // Jump to address if positive
+#define IHeaderJmpNAddr          0xC6 // Jump to address if negative
+#define IHeaderPutN              0xB0 // Put number
+#define IHeaderPutC              0xB1 // Put character
+
+#define ISizeHlt                 1    // {header}
+#define ISizeDbg                 1    // {header}
+#define ISizeLimits              11   // {header, value[2], value[4], value[4]}
+#define ISizeMovLiteral          10   // {header, reg, value[8]}
+#define ISizeMovRegister         3    // {header, reg, reg}
+#define ISizePush                9    // {header, value[8]}
+#define ISizeDup                 1    // {header}
+#define ISizeDrop                1    // {header}
+#define ISizeSwap                1    // {header}
+#define ISizeLd                  2    // {header, reg}
+#define ISizeSt                  2    // {header, reg}
+#define ISizeAdd                 1    // {header}
+#define ISizeSub                 1    // {header}
+#define ISizeMul                 1    // {header}
+#define ISizeDiv                 1    // {header}
+#define ISizeMod                 1    // {header}
+#define ISizeLabel               3    // {header, label[2]}
+#define ISizeCall                3    // {header, label[2]}
+#define ISizeJmp                 3    // {header, label[2]}
+#define ISizeJmpZ                3    // {header, label[2]}
+#define ISizeJmpNZ               3    // {header, label[2]}
+#define ISizeJmpP                3    // {header, label[2]}
+#define ISizeJmpN                3    // {header, label[2]}
+#define ISizeRet                 1    // {header}
+#define ISizeCallAddr            5    // {header, value[4]}
+#define ISizeJmpAddr             5    // {header, value[4]}
+#define ISizeJmpZAddr            5    // {header, value[4]}
+#define ISizeJmpNZAddr           5    // {header, value[4]}
+#define ISizeJmpPAddr            5    // {header, value[4]}
+#define ISizeJmpNAddr            5    // {header, value[4]}
+#define ISizePutN                1    // {header}
+#define ISizePutC                1    // {header}
+
+static const unsigned int ISizes[256] = {
+    [IHeaderHlt] = ISizeHlt,
+    [IHeaderDbg] = ISizeDbg,
+    [IHeaderLimits] = ISizeLimits,
+    [IHeaderMovLiteral] = ISizeMovLiteral,
+    [IHeaderMovRegister] = ISizeMovRegister,
+    [IHeaderPush] = ISizePush,
+    [IHeaderDup] = ISizeDup,
+    [IHeaderDrop] = ISizeDrop,
+    [IHeaderSwap] = ISizeSwap,
+    [IHeaderLd] = ISizeLd,
+    [IHeaderSt] = ISizeSt,
+    [IHeaderAdd] = ISizeAdd,
+    [IHeaderSub] = ISizeSub,
+    [IHeaderMul] = ISizeMul,
+    [IHeaderDiv] = ISizeDiv,
+    [IHeaderMod] = ISizeMod,
+    [IHeaderLabel] = ISizeLabel,
+    [IHeaderCall] = ISizeCall,
+    [IHeaderJmp] = ISizeJmp,
+    [IHeaderJmpZ] = ISizeJmpZ,
+    [IHeaderJmpNZ] = ISizeJmpNZ,
+    [IHeaderJmpP] = ISizeJmpP,
+    [IHeaderJmpN] = ISizeJmpN,
+    [IHeaderRet] = ISizeRet,
+    [IHeaderCallAddr] = ISizeCallAddr,
+    [IHeaderJmpAddr] = ISizeJmpAddr,
+    [IHeaderJmpZAddr] = ISizeJmpZAddr,
+    [IHeaderJmpNZAddr] = ISizeJmpNZAddr,
+    [IHeaderJmpPAddr] = ISizeJmpPAddr,
+    [IHeaderJmpNAddr] = ISizeJmpNAddr,
+    [IHeaderPutN] = ISizePutN,
+    [IHeaderPutC] = ISizePutC,
+};
+
+#endif
@@ -1,9 +1,12 @@
 #include <stdio.h>
 #include <stdlib.h>
 #include <stdint.h>
+#include <string.h>
 
-#define STACK_SIZE 256
-#define CALL_STACK_SIZE 256
+#define STACK_SIZE 1024
+#define CALL_STACK_SIZE 64
+#define REGISTER_COUNT 16
+#define FORMAT_VERSION 1
 
 // #define DEBUG
 
@@ -13,66 +16,19 @@
 #define debug(...)
 #endif
 
-#define IHeaderHlt 0x00         // Halt
-#define IHeaderDbg 0x01         // Debug
-#define IHeaderMovLiteral 0x08  // Move value
-#define IHeaderMovRegister 0x09 // Move register
-#define IHeaderPush 0x10        // Push value
-#define IHeaderDup 0x11         // Duplicate value
-#define IHeaderDrop 0x12        // Drop value
-#define IHeaderSwap 0x13        // Swap values
-#define IHeaderLd 0x20          // Load value
-#define IHeaderSt 0x21          // Store value
-#define IHeaderAdd 0x30         // Add
-#define IHeaderSub 0x31         // Subtract
-#define IHeaderMul 0x32         // Multiply
-#define IHeaderDiv 0x33         // Divide
-#define IHeaderMod 0x34         // Modulo
-#define IHeaderLabel 0xA0       // Label
-#define IHeaderCall 0xA1        // Call
-#define IHeaderJmp 0xA2         // Jump
-#define IHeaderJmpZ 0xA3        // Jump if zero
-#define IHeaderJmpNZ 0xA4       // Jump if not zero
-#define IHeaderJmpP 0xA5        // Jump if positive
-#define IHeaderJmpN 0xA6        // Jump if negative
-#define IHeaderRet 0xA7         // Return
-#define IHeaderPutN 0xB0        // Put number
-#define IHeaderPutC 0xB1        // Put character
-
-const unsigned int ISizeHlt = 1;         // {header}
-const unsigned int ISizeDbg = 1;         // {header}
-const unsigned int ISizeMovLiteral = 10; // {header, reg, value[8]}
-const unsigned int ISizeMovRegister = 3; // {header, reg, source}
-const unsigned int ISizePush = 9;        // {header, value[8]}
-const unsigned int ISizeDup = 1;         // {header}
-const unsigned int ISizeDrop = 1;        // {header}
-const unsigned int ISizeSwap = 1;        // {header}
-const unsigned int ISizeLd = 2;          // {header, reg}
-const unsigned int ISizeSt = 2;          // {header, reg}
-const unsigned int ISizeAdd = 1;         // {header}
-const unsigned int ISizeSub = 1;         // {header}
-const unsigned int ISizeMul = 1;         // {header}
-const unsigned int ISizeDiv = 1;         // {header}
-const unsigned int ISizeMod = 1;         // {header}
-const unsigned int ISizeLabel = 3;       // {header, label[2]}
-const unsigned int ISizeCall = 3;        // {header, label[2]}
-const unsigned int ISizeJmp = 3;         // {header, label[2]}
-const unsigned int ISizeJmpZ = 3;        // {header, label[2]}
-const unsigned int ISizeJmpNZ = 3;       // {header, label[2]}
-const unsigned int ISizeJmpP = 3;        // {header, label[2]}
-const unsigned int ISizeJmpN = 3;        // {header, label[2]}
-const unsigned int ISizeRet = 1;         // {header}
-const unsigned int ISizePutN = 1;        // {header}
-const unsigned int ISizePutC = 1;        // {header}
-
-int64_t stack[STACK_SIZE];
+#include "instructions.h"
+
+uint64_t stack_size = STACK_SIZE;
+int64_t *stack;
 uint64_t sp = 0;
 
-uint64_t call_stack[CALL_STACK_SIZE];
+uint64_t call_stack_size = CALL_STACK_SIZE;
+uint64_t *call_stack;
 uint64_t csp = 0;
 
 uint64_t jumps[1 << 16];
-uint64_t registers[16];
+uint64_t register_count = REGISTER_COUNT;
+uint64_t *registers;
 
 uint64_t ip = 0;
 
@@ -82,96 +38,27 @@ void build_jumps(uint8_t *buffer, long size)
 
     while (ip < size)
     {
-        switch (buffer[ip])
+        uint8_t header = buffer[ip];
+        unsigned int isize = ISizes[header];
+
+        if (isize == 0)
         {
-        case IHeaderHlt:
-            ip += ISizeHlt;
-            break;
-        case IHeaderDbg:
-            ip += ISizeDbg;
-            break;
-        case IHeaderMovLiteral:
-            ip += ISizeMovLiteral;
-            break;
-        case IHeaderMovRegister:
-            ip += ISizeMovRegister;
-            break;
-        case IHeaderPush:
-            ip += ISizePush;
-            break;
-        case IHeaderDup:
-            ip += ISizeDup;
-            break;
-        case IHeaderDrop:
-            ip += ISizeDrop;
-            break;
-        case IHeaderSwap:
-            ip += ISizeSwap;
-            break;
-        case IHeaderLd:
-            ip += ISizeLd;
-            break;
-        case IHeaderSt:
-            ip += ISizeSt;
-            break;
-        case IHeaderAdd:
-            ip += ISizeAdd;
-            break;
-        case IHeaderSub:
-            ip += ISizeSub;
-            break;
-        case IHeaderMul:
-            ip += ISizeMul;
-            break;
-        case IHeaderDiv:
-            ip += ISizeDiv;
-            break;
-        case IHeaderMod:
-            ip += ISizeMod;
-            break;
-        case IHeaderLabel:
-            ip += ISizeLabel;
-            jumps[(buffer[ip - 1] << 8) | buffer[ip - 2]] = ip;
-            break;
-        case IHeaderCall:
-            ip += ISizeCall;
-            break;
-        case IHeaderJmp:
-            ip += ISizeJmp;
-            break;
-        case IHeaderJmpZ:
-            ip += ISizeJmpZ;
-            break;
-        case IHeaderJmpNZ:
-            ip += ISizeJmpNZ;
-            break;
-        case IHeaderJmpP:
-            ip += ISizeJmpP;
-            break;
-        case IHeaderJmpN:
-            ip += ISizeJmpN;
-            break;
-        case IHeaderRet:
-            ip += ISizeRet;
-            break;
-        case IHeaderPutN:
-            ip += ISizePutN;
-            break;
-        case IHeaderPutC:
-            ip += ISizePutC;
-            break;
-        default:
-            printf("Error: unknown instruction %d\n", buffer[ip]);
+            printf("Error: unknown instruction %d\n", header);
             return;
         }
-    }
 
-    ip = 0;
+        ip += isize;
+
+        if (header == IHeaderLabel)
+        {
+            jumps[(buffer[ip - 1] << 8) | buffer[ip - 2]] = ip;
+        }
+    }
 }
 
 void push(uint64_t value)
 {
-    if (sp >= STACK_SIZE)
+    if (sp >= stack_size)
     {
         printf("Error: stack overflow\n");
         exit(1);
@@ -204,7 +91,7 @@ uint64_t peek()
 
 void call(uint64_t address)
 {
-    if (csp >= CALL_STACK_SIZE)
+    if (csp >= call_stack_size)
     {
         printf("Error: call stack overflow\n");
         exit(1);
@@ -229,6 +116,36 @@ uint16_t read_u16(uint8_t *buffer, uint64_t offset)
     return (buffer[offset + 1] << 8) | buffer[offset];
 }
 
+uint32_t read_u32(uint8_t *buffer, uint64_t offset)
+{
+    return (
+        (uint32_t)buffer[offset + 0] << 0 |
+        (uint32_t)buffer[offset + 1] << 8 |
+        (uint32_t)buffer[offset + 2] << 16 |
+        (uint32_t)buffer[offset + 3] << 24
+    );
+}
+
+void read_limits(uint8_t *buffer, long size)
+{
+    if (size >= ISizeLimits && buffer[0] == IHeaderLimits)
+    {
+        register_count = read_u16(buffer, 1);
+        stack_size = read_u32(buffer, 3);
+        call_stack_size = read_u32(buffer, 7);
+    }
+
+    stack = calloc(stack_size, sizeof(int64_t));
+    call_stack = calloc(call_stack_size, sizeof(uint64_t));
+    registers = calloc(register_count, sizeof(uint64_t));
+
+    if (!stack || !call_stack || !registers)
+    {
+        printf("Error: could not allocate VM state\n");
+        exit(1);
+    }
+}
+
 int64_t read_i64(uint8_t *buffer, uint64_t offset) {
     return (
         (uint64_t)buffer[offset + 0] << 0 |
@@ -405,6 +322,27 @@ void i_call(uint8_t *buffer)
     ip = jumps[addr];
 }
 
+void i_jmp_addr(uint8_t *buffer)
+{
+    ip = read_u32(buffer, ip + 1);
+}
+
+void i_jmp_addr_if(uint8_t *buffer, int cond)
+{
+    uint32_t addr = read_u32(buffer, ip + 1);
+    ip += ISizeJmpZAddr;
+    if (cond)
+    {
+        ip = addr;
+    }
+}
+
+void i_call_addr(uint8_t *buffer)
+{
+    call(ip + ISizeCallAddr);
+    ip = read_u32(buffer, ip + 1);
+}
+
 void i_ret(uint8_t *buffer)
 {
     ip = ret();
@@ -416,14 +354,123 @@ void i_putn(uint8_t *buffer)
     printf("%ld\n", pop());
 }
 
+// i_putc writes a code point as UTF-8, or U+FFFD if it is not a valid one, as
+// the Go VM does.
 void i_putc(uint8_t *buffer)
 {
     ip++;
-    printf("%c", (char)pop());
+    int64_t c = pop();
+
+    if (c < 0 || c > 0x10ffff || (c >= 0xd800 && c <= 0xdfff))
+    {
+        c = 0xfffd;
+    }
+
+    if (c < 0x80)
+    {
+        putchar(c);
+    }
+    else if (c < 0x800)
+    {
+        putchar(0xc0 | c >> 6);
+        putchar(0x80 | (c & 0x3f));
+    }
+    else if (c < 0x10000)
+    {
+        putchar(0xe0 | c >> 12);
+        putchar(0x80 | (c >> 6 & 0x3f));
+        putchar(0x80 | (c & 0x3f));
+    }
+    else
+    {
+        putchar(0xf0 | c >> 18);
+        putchar(0x80 | (c >> 12 & 0x3f));
+        putchar(0x80 | (c >> 6 & 0x3f));
+        putchar(0x80 | (c & 0x3f));
+    }
+}
+
+// container_crc is the CRC-32 of a whole container, taking its crc field as
+// zero.
+uint32_t container_crc(uint8_t *buffer, long size)
+{
+    uint32_t crc = 0xffffffff;
+
+    for (long i = 0; i < size; i++)
+    {
+        crc ^= i >= 14 && i < 18 ? 0 : buffer[i];
+
+        for (int bit = 0; bit < 8; bit++)
+        {
+            crc = (crc >> 1) ^ (0xedb88320 & -(crc & 1));
+        }
+    }
+
+    return ~crc;
+}
+
+int read_container(uint8_t **buffer, long *size)
+{
+    if (*size < 4 || memcmp(*buffer, "STOP", 4) != 0)
+    {
+        return 0;
+    }
+
+    if (*size < 18)
+    {
+        printf("Error: truncated container header\n");
+        exit(1);
+    }
+
+    uint16_t version = read_u16(*buffer, 4);
+    if (version == 0 || version > FORMAT_VERSION)
+    {
+        printf("Error: unsupported container format version %d\n", version);
+        exit(1);
+    }
+
+    if (read_u32(*buffer, 14) != container_crc(*buffer, *size))
+    {
+        printf("Error: container checksum mismatch\n");
+        exit(1);
+    }
+
+    uint16_t sections = read_u16(*buffer, 12);
+
+    for (uint16_t i = 0; i < sections; i++)
+    {
+        uint64_t entry = 18 + i * 9;
+
+        if (entry + 9 > *size)
+        {
+            break;
+        }
+
+        if ((*buffer)[entry] == 0x01)
+        {
+            uint32_t offset = read_u32(*buffer, entry + 1);
+            uint32_t length = read_u32(*buffer, entry + 5);
+
+            if ((uint64_t)offset + length > *size)
+            {
+                break;
+            }
+
+            ip = read_u32(*buffer, 8);
+            *buffer += offset;
+            *size = length;
+            return 1;
+        }
+    }
+
+    printf("Error: container has no code section\n");
+    exit(1);
 }
 
 int run(uint8_t *buffer, long size)
 {
+    read_container(&buffer, &size);
+    read_limits(buffer, size);
     build_jumps(buffer, size);
 
     while (ip < size) {
@@ -434,6 +481,9 @@ int run(uint8_t *buffer, long size)
             printf("ip=%ld, sp=%ld, csp=%ld\n", ip, sp, csp);
             ip += ISizeDbg;
             break;
+        case IHeaderLimits:
+            ip += ISizeLimits;
+            break;
         case IHeaderMovLiteral:
             debug("movl %d %ld\n", buffer[ip + 1], read_i64(buffer, ip + 2));
             i_mov_literal(buffer);
@@ -514,6 +564,30 @@ int run(uint8_t *buffer, long size)
             debug("jmpn %d\n", read_u16(buffer, ip + 1));
             i_jmpn(buffer);
             break;
+        case IHeaderCallAddr:
+            debug("call @%d\n", read_u32(buffer, ip + 1));
+            i_call_addr(buffer);
+            break;
+        case IHeaderJmpAddr:
+            debug("jmp @%d\n", read_u32(buffer, ip + 1));
+            i_jmp_addr(buffer);
+            break;
+        case IHeaderJmpZAddr:
+            debug("jmpz @%d\n", read_u32(buffer, ip + 1));
+            i_jmp_addr_if(buffer, pop() == 0);
+            break;
+        case IHeaderJmpNZAddr:
+            debug("jmpnz @%d\n", read_u32(buffer, ip + 1));
+            i_jmp_addr_if(buffer, pop() != 0);
+            break;
+        case IHeaderJmpPAddr:
+            debug("jmpp @%d\n", read_u32(buffer, ip + 1));
+            i_jmp_addr_if(buffer, pop() > 0);
+            break;
+        case IHeaderJmpNAddr:
+            debug("jmpn @%d\n", read_u32(buffer, ip + 1));
+            i_jmp_addr_if(buffer, pop() < 0);
+            break;
         case IHeaderRet:
             debug("ret\n");
             i_ret(buffer);
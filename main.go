@@ -1,41 +1,155 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/vcokltfre/stop/stop"
 )
 
-func build(file string) {
+type buildFlags struct {
+	debug  bool
+	depths bool
+	json   bool
+}
+
+// includePaths collects repeated -I flags.
+type includePaths []string
+
+func (p *includePaths) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *includePaths) Set(path string) error {
+	*p = append(*p, path)
+	return nil
+}
+
+// diagnose prints err, rendering diagnostics with their source or as JSON,
+// and exits.
+func diagnose(what string, err error, asJSON bool) {
+	diags, ok := err.(stop.Diagnostics)
+	if !ok {
+		fmt.Printf("Error %s: %s\n", what, err.Error())
+		os.Exit(1)
+	}
+
+	if asJSON {
+		out, _ := diags.JSON()
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(diags.Render())
+	}
+
+	os.Exit(1)
+}
+
+func build(file string, flags buildFlags, opts ...stop.ParseOption) {
 	data, err := os.ReadFile(file)
 	if err != nil {
 		fmt.Printf("Error reading file: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	parsed, err := stop.Parse(string(data))
+	unit, err := stop.ParseUnit(string(data), append(opts, stop.WithFilename(file))...)
+	if err != nil {
+		diagnose("parsing file", err, flags.json)
+	}
+
+	labels, err := stop.AnalyzeStack(unit)
+	if diags, ok := err.(stop.Diagnostics); ok {
+		err = append(unit.Warnings[:len(unit.Warnings):len(unit.Warnings)], diags...)
+	}
+	if err != nil {
+		diagnose("checking stack", err, flags.json)
+	}
+
+	if len(unit.Warnings) > 0 {
+		if flags.json {
+			out, _ := unit.Warnings.JSON()
+			fmt.Println(string(out))
+		} else {
+			fmt.Println(unit.Warnings.Render())
+		}
+	}
+
+	if flags.depths {
+		for _, label := range labels {
+			peak := fmt.Sprintf("%d", label.MaxDepth)
+			if label.MaxDepth < 0 {
+				peak = "unbounded"
+			}
+
+			fmt.Printf("%-16s needs %d, max depth %s\n", label.Name, label.Needs, peak)
+		}
+	}
+
+	compileOpts := []stop.CompileOption{}
+	if flags.debug {
+		compileOpts = append(compileOpts, stop.WithDebugInfo())
+	}
+
+	code, err := stop.CompileUnit(unit, compileOpts...)
 	if err != nil {
-		fmt.Printf("Error parsing file: %s\n", err.Error())
+		fmt.Printf("Error compiling file: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	err = os.WriteFile(file+".bc", stop.Compile(parsed), 0644)
+	err = os.WriteFile(file+".bc", code, 0644)
 	if err != nil {
 		fmt.Printf("Error writing file: %s\n", err.Error())
 		os.Exit(1)
 	}
 }
 
-func run(file string) {
+func run(file string, profile *stop.Profile, opts ...stop.Option) {
 	data, err := os.ReadFile(file)
 	if err != nil {
 		fmt.Printf("Error reading file: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	vm := stop.VM{}
-	vm.Run(data)
+	program, err := stop.Load(data)
+	if err != nil {
+		fmt.Printf("Error loading file: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if profile != nil {
+		opts = append(opts, stop.WithProfile(profile))
+	}
+
+	vm := stop.NewVM(program, opts...)
+	err = vm.Run(context.Background())
+
+	if profile != nil {
+		report(profile)
+	}
+
+	if err != nil {
+		fmt.Printf("Runtime error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func report(profile *stop.Profile) {
+	sequences := profile.Sequences()
+	if len(sequences) > 10 {
+		sequences = sequences[:10]
+	}
+
+	fmt.Fprintln(os.Stderr, "most common instruction sequences:")
+	for _, seq := range sequences {
+		fused := ""
+		if seq.Fused {
+			fused = " (fused)"
+		}
+
+		fmt.Fprintf(os.Stderr, "  %10d  %s%s\n", seq.Count, seq.Sequence, fused)
+	}
 }
 
 func explain(file string) {
@@ -45,32 +159,160 @@ func explain(file string) {
 		os.Exit(1)
 	}
 
-	stop.Explain(data)
+	if err := stop.Explain(data); err != nil {
+		fmt.Printf("Error explaining file: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func verify(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading file: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := stop.Verify(data); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+}
+
+func disasm(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading file: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	source, err := stop.Disasm(data)
+	if err != nil {
+		fmt.Printf("Error disassembling file: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Print(source)
+}
+
+type limitFlags struct {
+	limits stop.Limits
+	set    map[string]bool
+}
+
+func addLimitFlags(fs *flag.FlagSet) *limitFlags {
+	l := &limitFlags{limits: stop.DefaultLimits, set: map[string]bool{}}
+
+	fs.IntVar(&l.limits.Registers, "registers", l.limits.Registers, "number of registers")
+	fs.IntVar(&l.limits.StackSize, "stack", l.limits.StackSize, "stack size")
+	fs.IntVar(&l.limits.CallStackSize, "callstack", l.limits.CallStackSize, "call stack size")
+
+	return l
+}
+
+func (l *limitFlags) parse(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		l.set[f.Name] = true
+	})
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := l.limits.Validate(); err != nil {
+		fmt.Printf("Invalid limits: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	return fs.Arg(0)
+}
+
+func (l *limitFlags) vmOptions() []stop.Option {
+	opts := []stop.Option{}
+
+	if l.set["registers"] {
+		opts = append(opts, stop.WithRegisters(l.limits.Registers))
+	}
+
+	if l.set["stack"] {
+		opts = append(opts, stop.WithStackSize(l.limits.StackSize))
+	}
+
+	if l.set["callstack"] {
+		opts = append(opts, stop.WithCallStackSize(l.limits.CallStackSize))
+	}
+
+	return opts
 }
 
 func main() {
 	if len(os.Args) < 3 {
-		fmt.Printf("Usage: %s <build|run|explain> <file>\n", os.Args[0])
+		fmt.Printf("Usage: %s <build|run|explain|disasm|verify> [flags] <file>\n", os.Args[0])
 		os.Exit(1)
 	}
 
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s %s [flags] <file>\n", os.Args[0], os.Args[1])
+		fs.PrintDefaults()
+	}
+
 	switch os.Args[1] {
 	case "build":
-		build(os.Args[2])
+		limits := addLimitFlags(fs)
+		flags := buildFlags{}
+		fs.BoolVar(&flags.debug, "g", false, "emit debug info")
+		fs.BoolVar(&flags.depths, "depths", false, "print the stack depth each label needs")
+		fs.BoolVar(&flags.json, "json", false, "print diagnostics as JSON")
+		include := includePaths{}
+		fs.Var(&include, "I", "add a directory to search for included files")
+		file := limits.parse(fs, os.Args[2:])
+
+		build(file, flags, stop.WithLimits(limits.limits), stop.WithIncludePaths(include...))
 	case "run":
+		limits := addLimitFlags(fs)
+		profiling := fs.Bool("profile", false, "report the most common instruction sequences")
+		include := includePaths{}
+		fs.Var(&include, "I", "add a directory to search for included files when STOP_DEV=1")
+		file := limits.parse(fs, os.Args[2:])
+
+		var profile *stop.Profile
+		if *profiling {
+			profile = stop.NewProfile()
+		}
+
 		if os.Getenv("STOP_DEV") == "1" {
-			build(os.Args[2])
-			run(os.Args[2] + ".bc")
+			build(file, buildFlags{debug: true}, stop.WithLimits(limits.limits), stop.WithIncludePaths(include...))
+			run(file+".bc", profile, limits.vmOptions()...)
 			os.Exit(0)
 		}
-		run(os.Args[2])
-	case "explain":
+		run(file, profile, limits.vmOptions()...)
+	case "explain", "disasm", "verify":
+		include := includePaths{}
+		fs.Var(&include, "I", "add a directory to search for included files when STOP_DEV=1")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		file := fs.Arg(0)
+
+		command := map[string]func(string){
+			"explain": explain,
+			"disasm":  disasm,
+			"verify":  verify,
+		}[os.Args[1]]
+
 		if os.Getenv("STOP_DEV") == "1" {
-			build(os.Args[2])
-			explain(os.Args[2] + ".bc")
+			build(file, buildFlags{debug: true}, stop.WithIncludePaths(include...))
+			command(file + ".bc")
 			os.Exit(0)
 		}
-		explain(os.Args[2])
+		command(file)
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		os.Exit(1)
@@ -0,0 +1,308 @@
+package stop
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+// LabelDepth describes the stack a label's code needs. Needs is how many
+// values must already be on the stack when control reaches the label and
+// MaxDepth is the most it grows above that, or -1 if it has no bound.
+type LabelDepth struct {
+	Name     string
+	Needs    int
+	MaxDepth int
+}
+
+// summary is the stack effect of running from an instruction until it
+// returns: the values it needs on entry, the depth change at ret, and the
+// peak depth relative to entry.
+type summary struct {
+	known   bool
+	needs   int
+	returns bool
+	effect  int
+	max     int
+}
+
+type analyzer struct {
+	u         *Unit
+	targets   map[uint32]int
+	summaries map[int]summary
+	problems  map[int]string
+}
+
+// AnalyzeStack follows every path through a unit's control-flow graph,
+// tracking stack depth from the start of the program and through each called
+// label. It reports paths that can underflow, joins reached with different
+// depths and calls whose effect cannot be determined as Diagnostics, and
+// returns the stack each label needs.
+func AnalyzeStack(u *Unit) ([]LabelDepth, error) {
+	a := &analyzer{
+		u:         u,
+		targets:   map[uint32]int{},
+		summaries: map[int]summary{},
+		problems:  map[int]string{},
+	}
+
+	calls := []int{}
+	for i, inst := range u.Instructions {
+		switch inst := inst.(type) {
+		case instructions.InstLabel:
+			a.targets[inst.Label] = i
+		case instructions.InstCall:
+			calls = append(calls, int(inst.Label))
+		}
+	}
+
+	procs := []int{}
+	for _, label := range calls {
+		if target, ok := a.targets[uint32(label)]; ok && !contains(procs, target) {
+			procs = append(procs, target)
+		}
+	}
+
+	// Summaries of called labels depend on each other through recursion, so
+	// they are recomputed until they settle. A peak that is still growing
+	// after that comes from recursion that pushes without bound.
+	changed := []int{}
+	for round := 0; round <= len(procs)+16; round++ {
+		changed = changed[:0]
+
+		for _, proc := range procs {
+			s := a.walk(a.entry(proc), false)
+			if s != a.summaries[proc] {
+				a.summaries[proc] = s
+				changed = append(changed, proc)
+			}
+		}
+
+		if len(changed) == 0 {
+			break
+		}
+	}
+
+	for _, proc := range changed {
+		s := a.summaries[proc]
+		s.max = -1
+		a.summaries[proc] = s
+	}
+
+	if len(u.Instructions) > 0 {
+		a.walk(entry{main: true}, true)
+	}
+
+	for id := range u.Labels {
+		target, ok := a.targets[uint32(id)]
+		if !ok {
+			continue
+		}
+
+		if e := a.entry(target); e.sig != nil || contains(procs, target) {
+			a.walk(e, true)
+		}
+	}
+
+	depths := []LabelDepth{}
+	for id, name := range u.Labels {
+		target, ok := a.targets[uint32(id)]
+		if !ok {
+			continue
+		}
+
+		s := a.walk(a.entry(target), false)
+		depths = append(depths, LabelDepth{Name: name, Needs: s.needs, MaxDepth: s.max})
+	}
+
+	if len(a.problems) == 0 {
+		return depths, nil
+	}
+
+	indices := []int{}
+	for i := range a.problems {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	diags := Diagnostics{}
+	for _, i := range indices {
+		diags = append(diags, u.diagnostic(SeverityError, i, a.problems[i]))
+	}
+
+	return depths, diags
+}
+
+func contains(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *analyzer) label(id uint32) string {
+	if int(id) < len(a.u.Labels) {
+		return a.u.Labels[id]
+	}
+
+	return fmt.Sprintf("label %d", id)
+}
+
+func (a *analyzer) described(id uint32) string {
+	if int(id) < len(a.u.Signatures) && a.u.Signatures[id] != nil {
+		return a.label(id) + " " + a.u.Signatures[id].String()
+	}
+
+	return a.label(id)
+}
+
+func (a *analyzer) problem(report bool, i int, format string, args ...any) {
+	if _, ok := a.problems[i]; report && !ok {
+		a.problems[i] = fmt.Sprintf(format, args...)
+	}
+}
+
+// entry is where a walk starts. From the start of the program, or from a
+// label with a signature, the depth on entry is known and going below zero is
+// an error; from any other label depths are relative to entry and going below
+// zero raises what the label needs.
+type entry struct {
+	start int
+	depth int
+	main  bool
+	sig   *Signature
+	name  string
+}
+
+func (a *analyzer) entry(start int) entry {
+	label := a.u.Instructions[start].(instructions.InstLabel).Label
+
+	e := entry{start: start, name: a.label(label)}
+	if int(label) < len(a.u.Signatures) && a.u.Signatures[label] != nil {
+		e.sig = a.u.Signatures[label]
+		e.depth = len(e.sig.Inputs)
+	}
+
+	return e
+}
+
+func (a *analyzer) walk(e entry, report bool) summary {
+	s := summary{known: true}
+	depth := map[int]int{e.start: e.depth}
+	work := []int{e.start}
+	absolute := e.main || e.sig != nil
+
+	peak := e.depth
+	unbounded := false
+
+	underflow := func(i int, what string, needs, has int) {
+		if e.sig != nil {
+			a.problem(report, i, "%s needs %d values but only %d are available under %s %s", what, needs, has, e.name, e.sig)
+		} else {
+			a.problem(report, i, "%s can underflow the stack: needs %d, stack has %d", what, needs, has)
+		}
+	}
+
+	next := func(to, d int) {
+		if to >= len(a.u.Instructions) {
+			return
+		}
+
+		if seen, ok := depth[to]; ok {
+			if seen != d {
+				a.problem(report, to, "stack depth is %d on one path and %d on another", seen, d)
+			}
+			return
+		}
+
+		depth[to] = d
+		work = append(work, to)
+	}
+
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		inst := a.u.Instructions[i]
+		spec, _ := instructions.Lookup(inst.Opcode())
+		d := depth[i]
+
+		if spec.Pops > d {
+			if absolute {
+				underflow(i, spec.Mnemonic, spec.Pops, d)
+				continue
+			}
+
+			s.needs = max(s.needs, spec.Pops-d)
+		}
+
+		after := d - spec.Pops + spec.Pushes
+		peak = max(peak, after)
+
+		switch inst := inst.(type) {
+		case instructions.InstHlt:
+		case instructions.InstRet:
+			switch {
+			case e.main:
+				a.problem(report, i, "ret outside a called label")
+			case e.sig != nil:
+				if d != len(e.sig.Outputs) {
+					a.problem(report, i, "%s returns %d values but its signature %s declares %d", e.name, d, e.sig, len(e.sig.Outputs))
+				}
+				s.returns = true
+			case !s.returns:
+				s.returns, s.effect = true, d
+			case s.effect != d:
+				a.problem(report, i, "returns with %d values where another path returns with %d", d, s.effect)
+			}
+		case instructions.InstCall:
+			target := a.targets[inst.Label]
+			callee, ok := a.summaries[target]
+			if !ok || !callee.known {
+				a.problem(report, i, "stack effect of %s cannot be determined", a.label(inst.Label))
+				continue
+			}
+
+			if callee.needs > d {
+				if absolute {
+					underflow(i, "call to "+a.described(inst.Label), callee.needs, d)
+					continue
+				}
+
+				s.needs = max(s.needs, callee.needs-d)
+			}
+
+			if callee.max < 0 {
+				unbounded = true
+			}
+			peak = max(peak, d+callee.max)
+
+			if callee.returns {
+				next(i+1, d+callee.effect)
+			}
+		case instructions.InstJmp:
+			next(a.targets[inst.Label], after)
+		case instructions.InstJmpZ, instructions.InstJmpNZ, instructions.InstJmpP, instructions.InstJmpN:
+			next(a.targets[uint32(inst.Operands()[0])], after)
+			next(i+1, after)
+		default:
+			next(i+1, after)
+		}
+	}
+
+	s.max = peak - e.depth
+	if unbounded {
+		s.max = -1
+	}
+
+	if e.sig != nil {
+		s.needs = len(e.sig.Inputs)
+		s.effect = len(e.sig.Outputs) - len(e.sig.Inputs)
+	}
+
+	return s
+}
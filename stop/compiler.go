@@ -2,12 +2,42 @@ package stop
 
 import "github.com/vcokltfre/stop/stop/instructions"
 
-func Compile(instrs []instructions.Instruction) []byte {
-	out := []byte{}
+type CompileOption func(*compileConfig)
 
-	for _, instr := range instrs {
-		out = append(out, instr.Emit()...)
+type compileConfig struct {
+	debug bool
+}
+
+func WithDebugInfo() CompileOption {
+	return func(c *compileConfig) {
+		c.debug = true
+	}
+}
+
+func Compile(instrs []instructions.Instruction, opts ...CompileOption) ([]byte, error) {
+	return CompileUnit(&Unit{Instructions: instrs}, opts...)
+}
+
+func CompileUnit(u *Unit, opts ...CompileOption) ([]byte, error) {
+	config := compileConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	l, err := link(u.Instructions)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Container{
+		Version:  FORMAT_VERSION,
+		Sections: []Section{{Kind: SectionCode, Data: emit(l.insts)}},
+	}
+
+	if config.debug {
+		debug := newDebugInfo(u, l)
+		c.Sections = append(c.Sections, Section{Kind: SectionDebug, Data: debug.Encode()})
 	}
 
-	return out
+	return c.Encode(), nil
 }
@@ -0,0 +1,171 @@
+package stop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	FORMAT_VERSION = 1
+	LEGACY_VERSION = 0
+
+	headerSize  = 18 // {magic[4], version[2], flags[2], entry[4], sections[2], crc[4]}
+	sectionSize = 9  // {kind, offset[4], length[4]}
+)
+
+var magic = []byte("STOP")
+
+type SectionKind uint8
+
+const (
+	SectionCode    SectionKind = 0x01
+	SectionDebug   SectionKind = 0x02
+	SectionData    SectionKind = 0x03
+	SectionSymbols SectionKind = 0x04
+)
+
+func (k SectionKind) String() string {
+	switch k {
+	case SectionCode:
+		return "code"
+	case SectionDebug:
+		return "debug"
+	case SectionData:
+		return "data"
+	case SectionSymbols:
+		return "symbols"
+	default:
+		return fmt.Sprintf("unknown(%02x)", uint8(k))
+	}
+}
+
+type Section struct {
+	Kind SectionKind
+	Data []byte
+}
+
+type Container struct {
+	Version  uint16
+	Flags    uint16
+	Entry    uint32
+	Sections []Section
+}
+
+func IsContainer(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+func (c *Container) Section(kind SectionKind) ([]byte, bool) {
+	for _, section := range c.Sections {
+		if section.Kind == kind {
+			return section.Data, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *Container) Code() []byte {
+	code, _ := c.Section(SectionCode)
+	return code
+}
+
+func (c *Container) Encode() []byte {
+	table := make([]byte, 0, len(c.Sections)*sectionSize)
+	payload := []byte{}
+
+	offset := headerSize + len(c.Sections)*sectionSize
+	for _, section := range c.Sections {
+		table = append(table, uint8(section.Kind))
+		table = binary.LittleEndian.AppendUint32(table, uint32(offset+len(payload)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(section.Data)))
+		payload = append(payload, section.Data...)
+	}
+
+	out := append([]byte{}, magic...)
+	out = binary.LittleEndian.AppendUint16(out, c.Version)
+	out = binary.LittleEndian.AppendUint16(out, c.Flags)
+	out = binary.LittleEndian.AppendUint32(out, c.Entry)
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(c.Sections)))
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	out = append(append(out, table...), payload...)
+
+	binary.LittleEndian.PutUint32(out[14:18], checksum(out))
+
+	return out
+}
+
+// checksum is the CRC of a whole container, taking its crc field as zero.
+func checksum(data []byte) uint32 {
+	crc := crc32.ChecksumIEEE(data[:14])
+	crc = crc32.Update(crc, crc32.IEEETable, make([]byte, 4))
+	return crc32.Update(crc, crc32.IEEETable, data[headerSize:])
+}
+
+func ReadContainer(data []byte) (*Container, error) {
+	if !IsContainer(data) {
+		return &Container{
+			Version:  LEGACY_VERSION,
+			Sections: []Section{{Kind: SectionCode, Data: data}},
+		}, nil
+	}
+
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("invalid container: truncated header")
+	}
+
+	c := &Container{
+		Version: binary.LittleEndian.Uint16(data[4:6]),
+		Flags:   binary.LittleEndian.Uint16(data[6:8]),
+		Entry:   binary.LittleEndian.Uint32(data[8:12]),
+	}
+
+	if c.Version == LEGACY_VERSION || c.Version > FORMAT_VERSION {
+		return nil, fmt.Errorf("invalid container: unsupported format version %d", c.Version)
+	}
+
+	if c.Flags != 0 {
+		return nil, fmt.Errorf("invalid container: unknown flags %04x", c.Flags)
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[12:14]))
+	if crc := binary.LittleEndian.Uint32(data[14:18]); crc != checksum(data) {
+		return nil, fmt.Errorf("invalid container: checksum mismatch")
+	}
+
+	if len(data) < headerSize+count*sectionSize {
+		return nil, fmt.Errorf("invalid container: truncated section table")
+	}
+
+	seen := map[SectionKind]bool{}
+
+	for i := 0; i < count; i++ {
+		entry := data[headerSize+i*sectionSize:]
+		kind := SectionKind(entry[0])
+		offset := int(binary.LittleEndian.Uint32(entry[1:5]))
+		length := int(binary.LittleEndian.Uint32(entry[5:9]))
+
+		if offset < headerSize+count*sectionSize || offset+length > len(data) || offset+length < offset {
+			return nil, fmt.Errorf("invalid container: %s section out of bounds", kind)
+		}
+
+		if seen[kind] {
+			return nil, fmt.Errorf("invalid container: duplicate %s section", kind)
+		}
+
+		seen[kind] = true
+		c.Sections = append(c.Sections, Section{Kind: kind, Data: data[offset : offset+length]})
+	}
+
+	if !seen[SectionCode] {
+		return nil, fmt.Errorf("invalid container: missing code section")
+	}
+
+	if int(c.Entry) > len(c.Code()) {
+		return nil, fmt.Errorf("invalid container: entry point %x is outside the code section", c.Entry)
+	}
+
+	return c, nil
+}
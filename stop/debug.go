@@ -0,0 +1,219 @@
+package stop
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+type LineEntry struct {
+	Offset uint32
+	Line   uint32
+	Column uint16
+	File   uint16 // 0 for File, otherwise an index into Files plus one
+}
+
+// DebugInfo maps code back to source. Files names the included files, if
+// any; they are written after the labels, followed by the file of each line,
+// so that a section without them reads as before.
+type DebugInfo struct {
+	File   string
+	Files  []string
+	Lines  []LineEntry
+	Labels map[uint32]string // code offset -> label name
+}
+
+func newDebugInfo(u *Unit, l *linked) *DebugInfo {
+	d := &DebugInfo{File: u.File, Labels: map[uint32]string{}}
+
+	files := map[string]uint16{u.File: 0}
+	for i, pos := range u.Positions {
+		file, ok := files[pos.File]
+		if !ok {
+			d.Files = append(d.Files, pos.File)
+			file = uint16(len(d.Files))
+			files[pos.File] = file
+		}
+
+		d.Lines = append(d.Lines, LineEntry{Offset: uint32(l.offsets[i]), Line: uint32(pos.Line), Column: uint16(pos.Column), File: file})
+	}
+
+	for id := len(u.Labels) - 1; id >= 0; id-- {
+		if offset, ok := l.labels[uint32(id)]; ok {
+			d.Labels[uint32(offset)] = u.Labels[id]
+		}
+	}
+
+	return d
+}
+
+func (d *DebugInfo) Encode() []byte {
+	out := binary.LittleEndian.AppendUint16(nil, uint16(len(d.File)))
+	out = append(out, d.File...)
+
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(d.Lines)))
+	for _, line := range d.Lines {
+		out = binary.LittleEndian.AppendUint32(out, line.Offset)
+		out = binary.LittleEndian.AppendUint32(out, line.Line)
+		out = binary.LittleEndian.AppendUint16(out, line.Column)
+	}
+
+	offsets := make([]int, 0, len(d.Labels))
+	for offset := range d.Labels {
+		offsets = append(offsets, int(offset))
+	}
+	sort.Ints(offsets)
+
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(offsets)))
+	for _, offset := range offsets {
+		name := d.Labels[uint32(offset)]
+		out = binary.LittleEndian.AppendUint32(out, uint32(offset))
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(name)))
+		out = append(out, name...)
+	}
+
+	if len(d.Files) == 0 {
+		return out
+	}
+
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(d.Files)))
+	for _, file := range d.Files {
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(file)))
+		out = append(out, file...)
+	}
+
+	for _, line := range d.Lines {
+		out = binary.LittleEndian.AppendUint16(out, line.File)
+	}
+
+	return out
+}
+
+func DecodeDebugInfo(data []byte) (*DebugInfo, error) {
+	truncated := fmt.Errorf("invalid debug section: truncated")
+
+	take := func(n int) ([]byte, error) {
+		if len(data) < n {
+			return nil, truncated
+		}
+
+		out := data[:n]
+		data = data[n:]
+		return out, nil
+	}
+
+	d := &DebugInfo{Labels: map[uint32]string{}}
+
+	b, err := take(2)
+	if err != nil {
+		return nil, err
+	}
+	file, err := take(int(binary.LittleEndian.Uint16(b)))
+	if err != nil {
+		return nil, err
+	}
+	d.File = string(file)
+
+	b, err = take(4)
+	if err != nil {
+		return nil, err
+	}
+	for n := binary.LittleEndian.Uint32(b); n > 0; n-- {
+		entry, err := take(10)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Lines = append(d.Lines, LineEntry{
+			Offset: binary.LittleEndian.Uint32(entry[0:4]),
+			Line:   binary.LittleEndian.Uint32(entry[4:8]),
+			Column: binary.LittleEndian.Uint16(entry[8:10]),
+		})
+	}
+
+	b, err = take(4)
+	if err != nil {
+		return nil, err
+	}
+	for n := binary.LittleEndian.Uint32(b); n > 0; n-- {
+		b, err = take(4)
+		if err != nil {
+			return nil, err
+		}
+		offset := binary.LittleEndian.Uint32(b)
+
+		b, err = take(2)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := take(int(binary.LittleEndian.Uint16(b)))
+		if err != nil {
+			return nil, err
+		}
+
+		d.Labels[offset] = string(name)
+	}
+
+	if len(data) != 0 {
+		b, err = take(2)
+		if err != nil {
+			return nil, err
+		}
+
+		for n := binary.LittleEndian.Uint16(b); n > 0; n-- {
+			b, err = take(2)
+			if err != nil {
+				return nil, err
+			}
+
+			file, err := take(int(binary.LittleEndian.Uint16(b)))
+			if err != nil {
+				return nil, err
+			}
+
+			d.Files = append(d.Files, string(file))
+		}
+
+		for i := range d.Lines {
+			b, err = take(2)
+			if err != nil {
+				return nil, err
+			}
+
+			d.Lines[i].File = binary.LittleEndian.Uint16(b)
+			if int(d.Lines[i].File) > len(d.Files) {
+				return nil, fmt.Errorf("invalid debug section: line %d refers to file %d", i, d.Lines[i].File)
+			}
+		}
+	}
+
+	if len(data) != 0 {
+		return nil, fmt.Errorf("invalid debug section: %d trailing bytes", len(data))
+	}
+
+	sort.SliceStable(d.Lines, func(i, j int) bool {
+		return d.Lines[i].Offset < d.Lines[j].Offset
+	})
+
+	return d, nil
+}
+
+func (d *DebugInfo) Position(offset int) (Position, bool) {
+	i := sort.Search(len(d.Lines), func(i int) bool {
+		return int(d.Lines[i].Offset) > offset
+	})
+
+	if i == 0 {
+		return Position{}, false
+	}
+
+	line := d.Lines[i-1]
+
+	file := d.File
+	if line.File > 0 {
+		file = d.Files[line.File-1]
+	}
+
+	return Position{File: file, Line: int(line.Line), Column: int(line.Column)}, true
+}
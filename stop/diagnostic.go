@@ -0,0 +1,136 @@
+package stop
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a problem found in source. Line and Column are 1-based and
+// Span is the number of columns it covers. Source holds the offending line so
+// the diagnostic can be rendered without the file at hand. Notes point at
+// other places involved, such as the macro invocation an error came from.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Span     int      `json:"span"`
+	Message  string   `json:"message"`
+	Source   string   `json:"source,omitempty"`
+
+	Notes []Diagnostic `json:"notes,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	where := fmt.Sprintf("%d:%d", d.Line, d.Column)
+	if d.File != "" {
+		where = d.File + ":" + where
+	}
+
+	return fmt.Sprintf("%s: %s: %s", where, d.Severity, d.Message)
+}
+
+// Render returns the diagnostic followed by its source line with the span
+// underlined, then each of its notes.
+func (d Diagnostic) Render() string {
+	out := []string{d.render()}
+	for _, note := range d.Notes {
+		out = append(out, note.render())
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func (d Diagnostic) render() string {
+	out := &strings.Builder{}
+	out.WriteString(d.String())
+
+	if d.Source == "" {
+		return out.String()
+	}
+
+	gutter := fmt.Sprintf("%d", d.Line)
+	blank := strings.Repeat(" ", len(gutter))
+
+	fmt.Fprintf(out, "\n %s | %s\n %s | ", gutter, d.Source, blank)
+
+	// Copy tabs from the source so the caret lines up however they render.
+	for i, r := range d.Source {
+		if i >= d.Column-1 {
+			break
+		}
+
+		if r == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+
+	out.WriteString(strings.Repeat("^", max(d.Span, 1)))
+
+	return out.String()
+}
+
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := []string{}
+	for _, diag := range d {
+		lines = append(lines, diag.String())
+		for _, note := range diag.Notes {
+			lines = append(lines, note.String())
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (d Diagnostics) Render() string {
+	out := []string{}
+	for _, diag := range d {
+		out = append(out, diag.Render())
+	}
+
+	return strings.Join(out, "\n\n")
+}
+
+func (d Diagnostics) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+package stop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+func syntheticLabel(n int) string {
+	return fmt.Sprintf("l%d", n)
+}
+
+func Disasm(data []byte) (string, error) {
+	c, err := ReadContainer(data)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := loadCode(c)
+	if err != nil {
+		return "", err
+	}
+
+	if code.entry != 0 {
+		return "", fmt.Errorf("entry point %x cannot be expressed in source", code.entry)
+	}
+
+	insts, err := instructions.Decode(code.code)
+	if err != nil {
+		return "", err
+	}
+
+	offsets := instructions.Offsets(insts)
+
+	boundaries := map[int]bool{len(code.code): true}
+	for _, offset := range offsets {
+		boundaries[offset] = true
+	}
+
+	names := map[int]string{}
+	if code.debug != nil {
+		for offset, name := range code.debug.Labels {
+			if boundaries[int(offset)] {
+				names[int(offset)] = name
+			}
+		}
+	}
+
+	targets := []int{}
+	for _, inst := range insts {
+		spec, _ := instructions.Lookup(inst.Opcode())
+
+		for i, kind := range spec.Operands {
+			if kind != instructions.OperandAddress {
+				continue
+			}
+
+			target := int(inst.Operands()[i])
+			if !boundaries[target] {
+				return "", fmt.Errorf("%s refers to %x, which is not an instruction boundary", spec.Mnemonic, target)
+			}
+
+			targets = append(targets, target)
+		}
+	}
+	sort.Ints(targets)
+
+	used := map[string]bool{}
+	for _, name := range names {
+		used[name] = true
+	}
+
+	next := 0
+	fresh := func() string {
+		for used[syntheticLabel(next)] {
+			next++
+		}
+
+		used[syntheticLabel(next)] = true
+		return syntheticLabel(next)
+	}
+
+	for _, target := range targets {
+		if _, ok := names[target]; !ok {
+			names[target] = fresh()
+		}
+	}
+
+	// A local label is defined under the global label it belongs to, since
+	// its full name is not valid in a definition. Any other name that cannot
+	// be defined here, like those given to labels in macros and included
+	// files, or a local whose global label shares its offset with another and
+	// so is not the one written, is replaced.
+	defs := map[int]string{}
+	defined := map[string]bool{}
+	global := ""
+
+	for _, offset := range append(offsets[:len(offsets):len(offsets)], len(code.code)) {
+		name, ok := names[offset]
+		if !ok {
+			continue
+		}
+
+		local, isLocal := strings.CutPrefix(name, global+".")
+
+		switch {
+		case !defined[name] && global != "" && isLocal && isIdent(local):
+			defs[offset] = "." + local
+		case !defined[name] && isIdent(name):
+			defs[offset], global = name, name
+		default:
+			name = fresh()
+			defs[offset], global = name, name
+		}
+
+		names[offset] = name
+		defined[name] = true
+	}
+
+	out := &strings.Builder{}
+
+	label := func(offset int) {
+		if def, ok := defs[offset]; ok {
+			fmt.Fprintf(out, ":%s\n", def)
+		}
+	}
+
+	for i, inst := range insts {
+		label(offsets[i])
+
+		spec, _ := instructions.Lookup(inst.Opcode())
+		args := inst.Operands()
+
+		if spec.Symbolic {
+			return "", fmt.Errorf("unexpected %s instruction in linked code", spec.Name)
+		}
+
+		if inst, ok := inst.(instructions.InstLimits); ok {
+			fmt.Fprintf(out, ".limits %d %d %d\n", inst.Registers, inst.Stack, inst.CallStack)
+			continue
+		}
+
+		operands := []string{spec.Mnemonic}
+
+		for i, kind := range spec.Operands {
+			switch kind {
+			case instructions.OperandRegister:
+				operands = append(operands, fmt.Sprintf("r%d", args[i]))
+			case instructions.OperandAddress:
+				operands = append(operands, names[int(args[i])])
+			default:
+				operands = append(operands, fmt.Sprintf("%d", args[i]))
+			}
+		}
+
+		fmt.Fprintf(out, "    %s\n", strings.Join(operands, " "))
+	}
+
+	label(len(code.code))
+
+	return out.String(), nil
+}
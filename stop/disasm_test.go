@@ -0,0 +1,139 @@
+package stop
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDisasmRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"lib.stop": `
+:twice ( a -- b )
+    dup
+    add
+    ret
+:loop
+    jmp loop
+`,
+		"macros.stop": `
+.macro countdown n
+    push n
+:loop
+    dup
+    putn
+    push 1
+    swap
+    sub
+    dup
+    jmpnz loop
+    drop
+.endm
+:main
+    countdown 3
+:.after
+    countdown 2
+    jmp .after
+`,
+		"include.stop": `
+    push 21
+    call lib.twice
+    putn
+    hlt
+.include "lib.stop"
+`,
+		"locals.stop": `
+:outer
+    push 3
+:.loop
+    dup
+    jmpz .done
+    push 1
+    swap
+    sub
+    jmp .loop
+:.done
+    call inner
+    hlt
+:inner
+:.loop
+    jmp outer.done
+`,
+		"shared.stop": `
+    call c
+    hlt
+:b
+:c
+    push 1
+:.y
+    putn
+    ret
+`,
+	}
+
+	paths := []string{}
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if name != "lib.stop" {
+			paths = append(paths, path)
+		}
+	}
+
+	slices.Sort(paths)
+
+	examples, err := filepath.Glob("../examples/*.stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range append(examples, paths...) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		unit, err := ParseUnit(string(source), WithFilename(path))
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		want, err := CompileUnit(unit)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		for _, opts := range [][]CompileOption{nil, {WithDebugInfo()}} {
+			code, err := CompileUnit(unit, opts...)
+			if err != nil {
+				t.Fatalf("%s: %s", path, err)
+			}
+
+			text, err := Disasm(code)
+			if err != nil {
+				t.Fatalf("%s: disasm: %s", path, err)
+			}
+
+			insts, err := Parse(text)
+			if err != nil {
+				t.Fatalf("%s (debug info: %t): disassembly does not parse: %s\n%s", path, opts != nil, err, text)
+			}
+
+			got, err := Compile(insts)
+			if err != nil {
+				t.Fatalf("%s: %s", path, err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s (debug info: %t): reassembled code differs\n%s", path, opts != nil, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package stop
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ErrorKind uint8
+
+const (
+	KindStackOverflow ErrorKind = iota
+	KindStackUnderflow
+	KindCallStackOverflow
+	KindCallStackUnderflow
+	KindInvalidInstruction
+	KindTruncatedInstruction
+	KindInvalidRegister
+	KindDivisionByZero
+	KindIO
+	KindOutOfFuel
+	KindCancelled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindStackOverflow:
+		return "stack overflow"
+	case KindStackUnderflow:
+		return "stack underflow"
+	case KindCallStackOverflow:
+		return "call stack overflow"
+	case KindCallStackUnderflow:
+		return "call stack underflow"
+	case KindInvalidInstruction:
+		return "invalid instruction"
+	case KindTruncatedInstruction:
+		return "truncated instruction"
+	case KindInvalidRegister:
+		return "invalid register"
+	case KindDivisionByZero:
+		return "division by zero"
+	case KindIO:
+		return "i/o error"
+	case KindOutOfFuel:
+		return "out of fuel"
+	case KindCancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("unknown error (%d)", k)
+	}
+}
+
+type VMError struct {
+	Kind       ErrorKind
+	PC         int
+	Opcode     uint8
+	StackDepth int
+	Backtrace  []int
+	Err        error
+
+	Source string
+	Frames []string
+}
+
+func (e *VMError) Error() string {
+	msg := fmt.Sprintf("%s at %x (opcode %02x, stack depth %d)", e.Kind, e.PC, e.Opcode, e.StackDepth)
+	if e.Source != "" {
+		msg = e.Source + ": " + msg
+	}
+
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	if len(e.Backtrace) == 0 {
+		return msg
+	}
+
+	frames := make([]string, len(e.Backtrace))
+	for i, addr := range e.Backtrace {
+		frames[i] = fmt.Sprintf("%x", addr)
+		if i < len(e.Frames) && e.Frames[i] != "" {
+			frames[i] += " (" + e.Frames[i] + ")"
+		}
+	}
+
+	return msg + "\n  called from " + strings.Join(frames, "\n  called from ")
+}
+
+func (e *VMError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VMError) Resumable() bool {
+	return e.Kind == KindOutOfFuel || e.Kind == KindCancelled
+}
@@ -0,0 +1,297 @@
+package stop
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var precedence = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4,
+	">>": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// lexExpr splits line[from:to] into the tokens of a constant expression:
+// numbers and names as words, character literals, parentheses and operators.
+func lexExpr(line string, from, to int) ([]token, error) {
+	toks := []token{}
+
+	for i := from; i < to; {
+		c := line[i]
+
+		switch {
+		case isSpace(c):
+			i++
+		case c == '(' || c == ')':
+			kind := tokenOpen
+			if c == ')' {
+				kind = tokenClose
+			}
+
+			toks = append(toks, token{kind: kind, text: line[i : i+1], col: i})
+			i++
+		case c == '\'':
+			tok, err := lexQuoted(line[:to], i)
+			if err != nil {
+				return nil, err
+			}
+
+			toks = append(toks, tok)
+			i += len(tok.text)
+		case isNameChar(c):
+			start := i
+			for i < to && isNameChar(line[i]) {
+				i++
+			}
+
+			toks = append(toks, token{kind: tokenWord, text: line[start:i], col: start})
+		case strings.HasPrefix(line[i:to], "<<") || strings.HasPrefix(line[i:to], ">>"):
+			toks = append(toks, token{kind: tokenOperator, text: line[i : i+2], col: i})
+			i += 2
+		case strings.ContainsRune("+-*/%&|^~", rune(c)):
+			toks = append(toks, token{kind: tokenOperator, text: line[i : i+1], col: i})
+			i++
+		default:
+			_, size := utf8.DecodeRuneInString(line[i:])
+			return nil, errorAt(token{text: line[i : i+size], col: i}, "unexpected %s in expression", line[i:i+size])
+		}
+	}
+
+	return toks, nil
+}
+
+// value is the result of part of an expression and the columns it spans.
+type value struct {
+	v          int64
+	start, end int
+}
+
+type exprParser struct {
+	line   string
+	toks   []token
+	pos    int
+	end    int
+	consts map[string]int64
+}
+
+// evalExpr folds the constant expression val, which covers one or more
+// tokens of line, using the constants defined so far.
+func evalExpr(line string, val token, consts map[string]int64) (int64, error) {
+	end := val.col + len(val.text)
+
+	toks, err := lexExpr(line, val.col, end)
+	if err != nil {
+		return 0, err
+	}
+
+	p := &exprParser{line: line, toks: toks, end: end, consts: consts}
+
+	v, err := p.binary(1)
+	if err != nil {
+		return 0, err
+	}
+
+	if p.pos < len(p.toks) {
+		extra := p.toks[p.pos]
+		return 0, errorAt(extra, "unexpected %s in expression", extra.text)
+	}
+
+	return v.v, nil
+}
+
+func (p *exprParser) span(start, end int) token {
+	return token{text: p.line[start:end], col: start}
+}
+
+func (p *exprParser) peek() (token, bool) {
+	if p.pos == len(p.toks) {
+		return token{}, false
+	}
+
+	return p.toks[p.pos], true
+}
+
+func (p *exprParser) binary(min int) (value, error) {
+	left, err := p.unary()
+	if err != nil {
+		return value{}, err
+	}
+
+	for {
+		op, ok := p.peek()
+		if !ok || op.kind != tokenOperator || precedence[op.text] < min {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.binary(precedence[op.text] + 1)
+		if err != nil {
+			return value{}, err
+		}
+
+		v, err := apply(op.text, left.v, right.v)
+		if err != nil {
+			return value{}, errorAt(p.span(left.start, right.end), "%s", err)
+		}
+
+		left = value{v: v, start: left.start, end: right.end}
+	}
+}
+
+func (p *exprParser) unary() (value, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return value{}, errorAt(token{text: " ", col: p.end}, "expected a value")
+	}
+	p.pos++
+
+	switch tok.kind {
+	case tokenOpen:
+		inner, err := p.binary(1)
+		if err != nil {
+			return value{}, err
+		}
+
+		closing, ok := p.peek()
+		if !ok || closing.kind != tokenClose {
+			return value{}, errorAt(tok, "( is never closed")
+		}
+		p.pos++
+
+		return value{v: inner.v, start: tok.col, end: closing.col + 1}, nil
+	case tokenChar:
+		r, _ := utf8.DecodeRuneInString(tok.value)
+		return value{v: int64(r), start: tok.col, end: tok.col + len(tok.text)}, nil
+	case tokenWord:
+		return p.word(tok, "")
+	case tokenOperator:
+		if next, ok := p.peek(); ok && tok.text == "-" && next.kind == tokenWord && next.text[0] >= '0' && next.text[0] <= '9' {
+			// The sign is read with the digits so the most negative number
+			// can be written.
+			p.pos++
+			v, err := p.word(next, "-")
+			v.start = tok.col
+			return v, err
+		}
+
+		operand, err := p.unary()
+		if err != nil {
+			return value{}, err
+		}
+
+		v := value{v: operand.v, start: tok.col, end: operand.end}
+
+		switch tok.text {
+		case "+":
+		case "-":
+			if operand.v == math.MinInt64 {
+				return value{}, errorAt(p.span(v.start, v.end), "expression overflows 64 bits")
+			}
+			v.v = -operand.v
+		case "~":
+			v.v = ^operand.v
+		default:
+			return value{}, errorAt(tok, "unexpected %s in expression", tok.text)
+		}
+
+		return v, nil
+	default:
+		return value{}, errorAt(tok, "unexpected %s in expression", tok.text)
+	}
+}
+
+func (p *exprParser) word(tok token, sign string) (value, error) {
+	v := value{start: tok.col, end: tok.col + len(tok.text)}
+
+	if tok.text[0] >= '0' && tok.text[0] <= '9' {
+		n, err := parseNumber(sign + tok.text)
+		if errors.Is(err, strconv.ErrRange) {
+			return value{}, errorAt(tok, "number %s%s does not fit in 64 bits", sign, tok.text)
+		}
+		if err != nil {
+			return value{}, errorAt(tok, "invalid number %s", tok.text)
+		}
+
+		v.v = n
+		return v, nil
+	}
+
+	n, ok := p.consts[tok.text]
+	if !ok {
+		return value{}, errorAt(tok, "undefined constant %s", tok.text)
+	}
+
+	v.v = n
+	return v, nil
+}
+
+var errOverflow = errors.New("expression overflows 64 bits")
+
+func apply(op string, a, b int64) (int64, error) {
+	switch op {
+	case "+":
+		r := a + b
+		if (a >= 0) == (b >= 0) && (r >= 0) != (a >= 0) {
+			return 0, errOverflow
+		}
+		return r, nil
+	case "-":
+		r := a - b
+		if (a >= 0) != (b >= 0) && (r >= 0) != (a >= 0) {
+			return 0, errOverflow
+		}
+		return r, nil
+	case "*":
+		r := a * b
+		if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return 0, errOverflow
+		}
+		return r, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if op == "%" {
+			return a % b, nil
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, errOverflow
+		}
+		return a / b, nil
+	case "<<", ">>":
+		if b < 0 || b > 63 {
+			return 0, errors.New("shift count must be between 0 and 63")
+		}
+		if op == ">>" {
+			return a >> b, nil
+		}
+		if r := a << b; r>>b == a {
+			return r, nil
+		}
+		return 0, errOverflow
+	case "&":
+		return a & b, nil
+	case "|":
+		return a | b, nil
+	default:
+		return a ^ b, nil
+	}
+}
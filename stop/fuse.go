@@ -0,0 +1,250 @@
+package stop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+// fusion replaces a run of instructions with a single op. build receives the
+// plain ops of the run and returns the fused op, which must leave the VM in
+// the same state as running them one by one. Fused ops fall back to the plain
+// ops when an instruction in the run would fault, so faults report the
+// instruction that caused them.
+type fusion struct {
+	opcodes []uint8
+	build   func(ops []op) op
+}
+
+var fusions = []fusion{
+	{
+		opcodes: []uint8{instructions.IHeaderPush, instructions.IHeaderSwap, instructions.IHeaderSub},
+		build: func(ops []op) op {
+			return op{exec: (*VM).instPushSwapSub, val: ops[0].val}
+		},
+	},
+	{
+		opcodes: []uint8{instructions.IHeaderDup, instructions.IHeaderJmpZAddr},
+		build: func(ops []op) op {
+			return op{exec: (*VM).instDupJmpZ, target: ops[1].target}
+		},
+	},
+	{
+		opcodes: []uint8{instructions.IHeaderDup, instructions.IHeaderJmpNZAddr},
+		build: func(ops []op) op {
+			return op{exec: (*VM).instDupJmpNZ, target: ops[1].target}
+		},
+	},
+	{
+		opcodes: []uint8{instructions.IHeaderPush, instructions.IHeaderPutC},
+		build: func(ops []op) op {
+			return op{exec: (*VM).instPushPutC, val: ops[0].val}
+		},
+	},
+	{
+		opcodes: []uint8{instructions.IHeaderLd, instructions.IHeaderLd, instructions.IHeaderAdd},
+		build: func(ops []op) op {
+			return op{exec: (*VM).instLdLdAdd, a: ops[0].a, b: ops[1].a}
+		},
+	},
+}
+
+// fuse returns a copy of ops with fused ops at the head of every matching
+// run. The rest of each run is left in place so op indices are unchanged.
+// Runs never span a jump target, since jumping into the middle of a fused
+// op would skip its head.
+func fuse(code []byte, ops []op, start int) []op {
+	fused := append([]op{}, ops...)
+
+	targets := map[int]bool{start: true}
+	for _, o := range ops {
+		if o.pc == len(code) {
+			continue
+		}
+
+		spec, _ := instructions.Lookup(code[o.pc])
+		for _, kind := range spec.Operands {
+			if kind == instructions.OperandAddress {
+				targets[o.target] = true
+			}
+		}
+	}
+
+	opcode := func(i int) uint8 {
+		if ops[i].pc == len(code) {
+			return 0
+		}
+
+		return code[ops[i].pc]
+	}
+
+	for i := 0; i < len(ops); i++ {
+		for _, f := range fusions {
+			if !matches(f.opcodes, i, len(ops), opcode, targets) {
+				continue
+			}
+
+			o := f.build(ops[i : i+len(f.opcodes)])
+			o.pc = ops[i].pc
+			fused[i] = o
+
+			i += len(f.opcodes) - 1
+			break
+		}
+	}
+
+	return fused
+}
+
+func matches(opcodes []uint8, i, n int, opcode func(int) uint8, targets map[int]bool) bool {
+	if i+len(opcodes) > n {
+		return false
+	}
+
+	for j, want := range opcodes {
+		if opcode(i+j) != want || (j > 0 && targets[i+j]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unfused runs the n plain ops of the fused op being executed.
+func (v *VM) unfused(n int) error {
+	v.index = v.inst
+
+	for ; n > 0; n-- {
+		v.inst = v.index
+		o := &v.ops[v.index]
+		v.index++
+
+		if err := o.exec(v, o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *VM) instPushSwapSub(o *op) error {
+	if v.stackTop < 0 || v.stackTop >= len(v.stack)-1 {
+		return v.unfused(3)
+	}
+	v.stack[v.stackTop] -= o.val
+	v.index += 2
+	return nil
+}
+
+func (v *VM) instDupJmpZ(o *op) error {
+	if v.stackTop < 0 || v.stackTop >= len(v.stack)-1 {
+		return v.unfused(2)
+	}
+	if v.stack[v.stackTop] == 0 {
+		v.index = o.target
+	} else {
+		v.index++
+	}
+	return nil
+}
+
+func (v *VM) instDupJmpNZ(o *op) error {
+	if v.stackTop < 0 || v.stackTop >= len(v.stack)-1 {
+		return v.unfused(2)
+	}
+	if v.stack[v.stackTop] != 0 {
+		v.index = o.target
+	} else {
+		v.index++
+	}
+	return nil
+}
+
+func (v *VM) instLdLdAdd(o *op) error {
+	if v.stackTop >= len(v.stack)-2 {
+		return v.unfused(3)
+	}
+	v.stackTop++
+	v.stack[v.stackTop] = v.registers[o.a] + v.registers[o.b]
+	v.index += 2
+	return nil
+}
+
+// instPushPutC writes its character without touching the stack, which prints
+// produces for every character of its string.
+func (v *VM) instPushPutC(o *op) error {
+	if v.stackTop >= len(v.stack)-1 {
+		return v.unfused(2)
+	}
+	v.inst = v.index
+	v.index++
+	if _, err := fmt.Fprintf(v.out, "%c", o.val); err != nil {
+		return v.fault(KindIO)
+	}
+	return nil
+}
+
+type SequenceCount struct {
+	Sequence string
+	Count    int64
+	Fused    bool
+}
+
+// Profile counts the instruction sequences a VM executes. Sequences of two and
+// three instructions are counted, restarting after every taken jump, call or
+// return, since only straight-line runs can be fused.
+type Profile struct {
+	counts map[string]int64
+	window []uint8
+}
+
+func NewProfile() *Profile {
+	return &Profile{counts: map[string]int64{}}
+}
+
+func (p *Profile) observe(opcode uint8, jumped bool) {
+	p.window = append(p.window, opcode)
+	if len(p.window) > 3 {
+		p.window = p.window[1:]
+	}
+
+	for n := 2; n <= len(p.window); n++ {
+		p.counts[string(p.window[len(p.window)-n:])]++
+	}
+
+	if jumped {
+		p.window = p.window[:0]
+	}
+}
+
+// Sequences returns the counted sequences, most frequent first.
+func (p *Profile) Sequences() []SequenceCount {
+	out := []SequenceCount{}
+
+	for key, count := range p.counts {
+		names := []string{}
+		for _, opcode := range []byte(key) {
+			spec, _ := instructions.Lookup(opcode)
+			names = append(names, spec.Mnemonic)
+		}
+
+		fused := false
+		for _, f := range fusions {
+			fused = fused || string(f.opcodes) == key
+		}
+
+		out = append(out, SequenceCount{Sequence: strings.Join(names, "; "), Count: count, Fused: fused})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+
+		return out[i].Sequence < out[j].Sequence
+	})
+
+	return out
+}
@@ -0,0 +1,89 @@
+package stop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// run runs program on the given ops, returning everything a fused op could
+// get wrong.
+func run(program *Program, plain bool) (string, []int64, []int64, error) {
+	out := &bytes.Buffer{}
+	v := NewVM(program, WithOutput(out))
+	if plain {
+		v.fused = v.ops
+	}
+
+	err := v.Run(context.Background())
+	return out.String(), v.Stack(), v.Registers(), err
+}
+
+func TestFusedOps(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		fails  bool
+	}{
+		{"push swap sub", "push 10\npush 3\nswap\nsub\nputn\n", false},
+		{"push swap sub underflow", "push 3\nswap\nsub\n", true},
+		{"push swap sub overflow", ".limits 16 1 64\npush 10\npush 3\nswap\nsub\n", true},
+		{"dup jmpz taken", "push 0\ndup\njmpz end\npush 1\n:end\nputn\n", false},
+		{"dup jmpz not taken", "push 5\ndup\njmpz end\npush 1\n:end\nputn\n", false},
+		{"dup jmpz underflow", "dup\njmpz end\n:end\n", true},
+		{"dup jmpz overflow", ".limits 16 1 64\npush 0\ndup\njmpz end\n:end\n", true},
+		{"dup jmpnz taken", "push 5\ndup\njmpnz end\npush 1\n:end\nputn\n", false},
+		{"dup jmpnz not taken", "push 0\ndup\njmpnz end\npush 1\n:end\nputn\n", false},
+		{"dup jmpnz underflow", "dup\njmpnz end\n:end\n", true},
+		{"dup jmpnz overflow", ".limits 16 1 64\npush 5\ndup\njmpnz end\n:end\n", true},
+		{"ld ld add", "mov r0 2\nmov r1 40\nld r0\nld r1\nadd\nputn\n", false},
+		{"ld ld add wrapping", "mov r0 9223372036854775807\nmov r1 1\nld r0\nld r1\nadd\nputn\n", false},
+		{"ld ld add overflow", ".limits 16 1 64\nld r0\nld r1\nadd\n", true},
+		{"ld ld add overflow late", ".limits 16 2 64\npush 1\nld r0\nld r1\nadd\n", true},
+		{"push putc", "prints \"h\\u{e9}!\\n\"\npush 7\n", false},
+		{"push putc overflow", ".limits 16 1 64\npush 1\npush 'x'\nputc\n", true},
+		{"push swap sub underflow in a call", ":main\n    call f\n    hlt\n:f\n    push 1\n    swap\n    sub\n    ret\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program := load(t, test.source)
+
+			fused := false
+			for i := range program.ops {
+				fused = fused || reflect.ValueOf(program.ops[i].exec).Pointer() != reflect.ValueOf(program.fused[i].exec).Pointer()
+			}
+
+			if !fused {
+				t.Fatal("nothing was fused")
+			}
+
+			wantOut, wantStack, wantRegs, wantErr := run(program, true)
+			if (wantErr != nil) != test.fails {
+				t.Fatalf("plain ops gave %v", wantErr)
+			}
+
+			gotOut, gotStack, gotRegs, gotErr := run(program, false)
+
+			if gotOut != wantOut || !slices.Equal(gotStack, wantStack) || !slices.Equal(gotRegs, wantRegs) {
+				t.Errorf("fused ops gave output %q, stack %v and registers %v, want %q, %v and %v",
+					gotOut, gotStack, gotRegs, wantOut, wantStack, wantRegs)
+			}
+
+			var want, got *VMError
+			errors.As(wantErr, &want)
+			errors.As(gotErr, &got)
+
+			switch {
+			case (want == nil) != (got == nil):
+				t.Errorf("fused ops gave %v, want %v", gotErr, wantErr)
+			case want != nil && (got.Kind != want.Kind || got.PC != want.PC || got.StackDepth != want.StackDepth || !slices.Equal(got.Backtrace, want.Backtrace)):
+				t.Errorf("fused ops gave %s at %x with depth %d and backtrace %x, want %s at %x with depth %d and backtrace %x",
+					got.Kind, got.PC, got.StackDepth, got.Backtrace, want.Kind, want.PC, want.StackDepth, want.Backtrace)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package stop
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includer reads a source file and the files it includes into one list of
+// lines. Each file is read once however often it is included, and the labels
+// of an included file are namespaced by its name without the extension.
+type includer struct {
+	paths []string
+	unit  *Unit
+	fail  func(*sourceLine, error)
+
+	namespaces map[string]string // file -> namespace, "" for the main file
+	owners     map[string]string // namespace -> file
+	order      map[string]int    // file -> position in the order files were read
+	seen       map[string]bool
+	stack      []string // absolute paths of the files being read
+	names      []string // and their names
+}
+
+func namespaceOf(file string) string {
+	base := filepath.Base(file)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// load lexes the lines of file, replacing each .include with the lines of
+// the file it names.
+func (in *includer) load(file, abs string, lines []string, namespace string) []*sourceLine {
+	in.unit.source[file] = lines
+	in.namespaces[file] = namespace
+	in.order[file] = len(in.order)
+	in.seen[abs] = true
+
+	in.stack = append(in.stack, abs)
+	in.names = append(in.names, file)
+	defer func() {
+		in.stack = in.stack[:len(in.stack)-1]
+		in.names = in.names[:len(in.names)-1]
+	}()
+
+	out := []*sourceLine{}
+	for i, line := range lines {
+		s := &sourceLine{file: file, line: i + 1, text: line}
+
+		toks, err := lexLine(line)
+		if err != nil {
+			in.fail(s, err)
+		}
+		s.toks = toks
+
+		if len(toks) > 0 && toks[0].kind == tokenWord && toks[0].text == ".include" {
+			included, err := in.include(s)
+			if err != nil {
+				in.fail(s, err)
+			}
+
+			out = append(out, included...)
+			continue
+		}
+
+		out = append(out, s)
+	}
+
+	return out
+}
+
+func (in *includer) include(s *sourceLine) ([]*sourceLine, error) {
+	toks := s.toks
+
+	if len(toks) != 2 {
+		return nil, errorAt(spanning(s.text, toks), ".include must have one argument")
+	}
+
+	path := toks[1]
+	if path.kind != tokenString {
+		return nil, errorAt(path, ".include argument must be a string")
+	}
+
+	file, ok := in.resolve(s.file, path.value)
+	if !ok {
+		return nil, errorAt(path, "cannot find %s", path.value)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, errorAt(path, "cannot resolve %s: %s", path.value, err)
+	}
+
+	for i, open := range in.stack {
+		if open == abs {
+			cycle := append(append([]string{}, in.names[i:]...), file)
+			return nil, errorAt(path, "include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if in.seen[abs] {
+		return nil, nil
+	}
+
+	namespace := namespaceOf(file)
+	if owner, ok := in.owners[namespace]; ok {
+		return nil, errorAt(path, "%s would share the namespace %s with %s", file, namespace, owner)
+	}
+	in.owners[namespace] = file
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errorAt(path, "cannot read %s: %s", file, err)
+	}
+
+	return in.load(file, abs, strings.Split(string(data), "\n"), namespace), nil
+}
+
+// resolve finds an included path relative to the including file, then in
+// each include path in turn.
+func (in *includer) resolve(from, path string) (string, bool) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(filepath.Dir(from), path)}
+		for _, dir := range in.paths {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
@@ -8,6 +8,14 @@ func i64ToBytes(i int64) []byte {
 	return b
 }
 
+func u32ToBytes(i uint32) []byte {
+	b := make([]byte, 4)
+	for j := 0; j < 4; j++ {
+		b[j] = byte(i >> uint32(j*8))
+	}
+	return b
+}
+
 func u16ToBytes(i uint16) []byte {
 	b := make([]byte, 2)
 	for j := 0; j < 2; j++ {
@@ -16,10 +24,22 @@ func u16ToBytes(i uint16) []byte {
 	return b
 }
 
-// func bytesToI64(b []byte) int64 {
-// 	var i int64
-// 	for j := 0; j < 8; j++ {
-// 		i |= int64(b[j]) << uint64(j*8)
-// 	}
-// 	return i
-// }
+func bytesToI64(b []byte) int64 {
+	var i int64
+	for j := 0; j < 8; j++ {
+		i |= int64(b[j]) << uint64(j*8)
+	}
+	return i
+}
+
+func bytesToU32(b []byte) uint32 {
+	var i uint32
+	for j := 0; j < 4; j++ {
+		i |= uint32(b[j]) << uint32(j*8)
+	}
+	return i
+}
+
+func bytesToU16(b []byte) uint16 {
+	return uint16(b[1])<<8 | uint16(b[0])
+}
@@ -5,3 +5,11 @@ type InstDbg struct{}
 func (i InstDbg) Emit() []byte {
 	return []byte{IHeaderDbg}
 }
+
+func (i InstDbg) Opcode() uint8 {
+	return IHeaderDbg
+}
+
+func (i InstDbg) Operands() []int64 {
+	return nil
+}
@@ -0,0 +1,62 @@
+package instructions
+
+import "fmt"
+
+type DecodeError struct {
+	Offset int
+	Opcode uint8
+	Msg    string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at %x (opcode %02x)", e.Msg, e.Offset, e.Opcode)
+}
+
+func DecodeAt(code []byte, offset int) (Instruction, int, error) {
+	if offset < 0 || offset >= len(code) {
+		return nil, 0, &DecodeError{Offset: offset, Msg: "offset out of range"}
+	}
+
+	curr := code[offset]
+
+	spec, ok := Lookup(curr)
+	if !ok {
+		return nil, 0, &DecodeError{Offset: offset, Opcode: curr, Msg: "unknown opcode"}
+	}
+
+	if offset+spec.Size > len(code) {
+		return nil, 0, &DecodeError{Offset: offset, Opcode: curr, Msg: fmt.Sprintf("truncated %s operands", spec.Mnemonic)}
+	}
+
+	return spec.Build(ReadOperands(spec, code[offset:])), spec.Size, nil
+}
+
+func Decode(code []byte) ([]Instruction, error) {
+	insts := []Instruction{}
+
+	for offset := 0; offset < len(code); {
+		inst, size, err := DecodeAt(code, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		insts = append(insts, inst)
+		offset += size
+	}
+
+	return insts, nil
+}
+
+func Offsets(insts []Instruction) []int {
+	offsets := make([]int, len(insts))
+
+	offset := 0
+	for i, inst := range insts {
+		offsets[i] = offset
+
+		spec, _ := Lookup(inst.Opcode())
+		offset += spec.Size
+	}
+
+	return offsets
+}
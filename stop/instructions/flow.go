@@ -1,59 +1,115 @@
 package instructions
 
 type InstLabel struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstLabel) Emit() []byte {
-	return append([]byte{IHeaderLabel}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderLabel}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstLabel) Opcode() uint8 {
+	return IHeaderLabel
+}
+
+func (i InstLabel) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstCall struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstCall) Emit() []byte {
-	return append([]byte{IHeaderCall}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderCall}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstCall) Opcode() uint8 {
+	return IHeaderCall
+}
+
+func (i InstCall) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstJmp struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstJmp) Emit() []byte {
-	return append([]byte{IHeaderJmp}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderJmp}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstJmp) Opcode() uint8 {
+	return IHeaderJmp
+}
+
+func (i InstJmp) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstJmpZ struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstJmpZ) Emit() []byte {
-	return append([]byte{IHeaderJmpZ}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderJmpZ}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstJmpZ) Opcode() uint8 {
+	return IHeaderJmpZ
+}
+
+func (i InstJmpZ) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstJmpNZ struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstJmpNZ) Emit() []byte {
-	return append([]byte{IHeaderJmpNZ}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderJmpNZ}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstJmpNZ) Opcode() uint8 {
+	return IHeaderJmpNZ
+}
+
+func (i InstJmpNZ) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstJmpP struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstJmpP) Emit() []byte {
-	return append([]byte{IHeaderJmpP}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderJmpP}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstJmpP) Opcode() uint8 {
+	return IHeaderJmpP
+}
+
+func (i InstJmpP) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstJmpN struct {
-	Label uint16
+	Label uint32
 }
 
 func (i InstJmpN) Emit() []byte {
-	return append([]byte{IHeaderJmpN}, u16ToBytes(i.Label)...)
+	return append([]byte{IHeaderJmpN}, u16ToBytes(uint16(i.Label))...)
+}
+
+func (i InstJmpN) Opcode() uint8 {
+	return IHeaderJmpN
+}
+
+func (i InstJmpN) Operands() []int64 {
+	return []int64{int64(i.Label)}
 }
 
 type InstRet struct{}
@@ -61,3 +117,107 @@ type InstRet struct{}
 func (i InstRet) Emit() []byte {
 	return []byte{IHeaderRet}
 }
+
+func (i InstRet) Opcode() uint8 {
+	return IHeaderRet
+}
+
+func (i InstRet) Operands() []int64 {
+	return nil
+}
+
+type InstCallAddr struct {
+	Addr uint32
+}
+
+func (i InstCallAddr) Emit() []byte {
+	return append([]byte{IHeaderCallAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstCallAddr) Opcode() uint8 {
+	return IHeaderCallAddr
+}
+
+func (i InstCallAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
+
+type InstJmpAddr struct {
+	Addr uint32
+}
+
+func (i InstJmpAddr) Emit() []byte {
+	return append([]byte{IHeaderJmpAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstJmpAddr) Opcode() uint8 {
+	return IHeaderJmpAddr
+}
+
+func (i InstJmpAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
+
+type InstJmpZAddr struct {
+	Addr uint32
+}
+
+func (i InstJmpZAddr) Emit() []byte {
+	return append([]byte{IHeaderJmpZAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstJmpZAddr) Opcode() uint8 {
+	return IHeaderJmpZAddr
+}
+
+func (i InstJmpZAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
+
+type InstJmpNZAddr struct {
+	Addr uint32
+}
+
+func (i InstJmpNZAddr) Emit() []byte {
+	return append([]byte{IHeaderJmpNZAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstJmpNZAddr) Opcode() uint8 {
+	return IHeaderJmpNZAddr
+}
+
+func (i InstJmpNZAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
+
+type InstJmpPAddr struct {
+	Addr uint32
+}
+
+func (i InstJmpPAddr) Emit() []byte {
+	return append([]byte{IHeaderJmpPAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstJmpPAddr) Opcode() uint8 {
+	return IHeaderJmpPAddr
+}
+
+func (i InstJmpPAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
+
+type InstJmpNAddr struct {
+	Addr uint32
+}
+
+func (i InstJmpNAddr) Emit() []byte {
+	return append([]byte{IHeaderJmpNAddr}, u32ToBytes(i.Addr)...)
+}
+
+func (i InstJmpNAddr) Opcode() uint8 {
+	return IHeaderJmpNAddr
+}
+
+func (i InstJmpNAddr) Operands() []int64 {
+	return []int64{int64(i.Addr)}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+func layout(spec instructions.Spec) string {
+	parts := []string{"header"}
+
+	for _, op := range spec.Operands {
+		switch op {
+		case instructions.OperandRegister:
+			parts = append(parts, "reg")
+		case instructions.OperandLabel:
+			parts = append(parts, "label[2]")
+		default:
+			parts = append(parts, fmt.Sprintf("value[%d]", op.Size()))
+		}
+	}
+
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Printf("Usage: %s <output>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	out := &bytes.Buffer{}
+
+	fmt.Fprintln(out, "// Code generated by stop/instructions/gen; DO NOT EDIT.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "#ifndef STOP_INSTRUCTIONS_H")
+	fmt.Fprintln(out, "#define STOP_INSTRUCTIONS_H")
+	fmt.Fprintln(out)
+
+	for _, spec := range instructions.Table {
+		fmt.Fprintf(out, "#define %-24s 0x%02X // %s\n", "IHeader"+spec.Name, spec.Opcode, spec.Doc)
+	}
+
+	fmt.Fprintln(out)
+
+	for _, spec := range instructions.Table {
+		fmt.Fprintf(out, "#define %-24s %-4d // %s\n", "ISize"+spec.Name, spec.Size, layout(spec))
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "static const unsigned int ISizes[256] = {")
+
+	for _, spec := range instructions.Table {
+		fmt.Fprintf(out, "    [%s] = %s,\n", "IHeader"+spec.Name, "ISize"+spec.Name)
+	}
+
+	fmt.Fprintln(out, "};")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "#endif")
+
+	if err := os.WriteFile(os.Args[1], out.Bytes(), 0644); err != nil {
+		fmt.Printf("Error writing file: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
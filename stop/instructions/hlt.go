@@ -5,3 +5,11 @@ type InstHlt struct{}
 func (i InstHlt) Emit() []byte {
 	return []byte{IHeaderHlt}
 }
+
+func (i InstHlt) Opcode() uint8 {
+	return IHeaderHlt
+}
+
+func (i InstHlt) Operands() []int64 {
+	return nil
+}
@@ -4,6 +4,8 @@ const (
 	IHeaderHlt uint8 = 0x00 // Halt
 	IHeaderDbg uint8 = 0x01 // Debug
 
+	IHeaderLimits uint8 = 0x02 // Limits
+
 	IHeaderMovLiteral  uint8 = 0x08 // Move value
 	IHeaderMovRegister uint8 = 0x09 // Move register
 
@@ -30,6 +32,13 @@ const (
 	IHeaderJmpN  uint8 = 0xA6 // Jump if negative
 	IHeaderRet   uint8 = 0xA7 // Return
 
+	IHeaderCallAddr  uint8 = 0xC1 // Call address
+	IHeaderJmpAddr   uint8 = 0xC2 // Jump to address
+	IHeaderJmpZAddr  uint8 = 0xC3 // Jump to address if zero
+	IHeaderJmpNZAddr uint8 = 0xC4 // Jump to address if not zero
+	IHeaderJmpPAddr  uint8 = 0xC5 // Jump to address if positive
+	IHeaderJmpNAddr  uint8 = 0xC6 // Jump to address if negative
+
 	IHeaderPutN uint8 = 0xB0 // Put number
 	IHeaderPutC uint8 = 0xB1 // Put character
 )
@@ -38,6 +47,8 @@ const (
 	ISizeHlt = 1 // {header}
 	ISizeDbg = 1 // {header}
 
+	ISizeLimits = 11 // {header, registers[2], stack[4], callstack[4]}
+
 	ISizeMovLiteral  = 10 // {header, reg, value[8]}
 	ISizeMovRegister = 3  // {header, reg, source}
 
@@ -64,10 +75,19 @@ const (
 	ISizeJmpN  = 3 // {header, label[2]}
 	ISizeRet   = 1 // {header}
 
+	ISizeCallAddr  = 5 // {header, addr[4]}
+	ISizeJmpAddr   = 5 // {header, addr[4]}
+	ISizeJmpZAddr  = 5 // {header, addr[4]}
+	ISizeJmpNZAddr = 5 // {header, addr[4]}
+	ISizeJmpPAddr  = 5 // {header, addr[4]}
+	ISizeJmpNAddr  = 5 // {header, addr[4]}
+
 	ISizePutN = 1 // {header}
 	ISizePutC = 1 // {header}
 )
 
 type Instruction interface {
 	Emit() []byte
+	Opcode() uint8
+	Operands() []int64
 }
@@ -6,8 +6,24 @@ func (i InstPutN) Emit() []byte {
 	return []byte{IHeaderPutN}
 }
 
+func (i InstPutN) Opcode() uint8 {
+	return IHeaderPutN
+}
+
+func (i InstPutN) Operands() []int64 {
+	return nil
+}
+
 type InstPutC struct{}
 
 func (i InstPutC) Emit() []byte {
 	return []byte{IHeaderPutC}
 }
+
+func (i InstPutC) Opcode() uint8 {
+	return IHeaderPutC
+}
+
+func (i InstPutC) Operands() []int64 {
+	return nil
+}
@@ -0,0 +1,21 @@
+package instructions
+
+type InstLimits struct {
+	Registers uint16
+	Stack     uint32
+	CallStack uint32
+}
+
+func (i InstLimits) Emit() []byte {
+	out := append([]byte{IHeaderLimits}, u16ToBytes(i.Registers)...)
+	out = append(out, u32ToBytes(i.Stack)...)
+	return append(out, u32ToBytes(i.CallStack)...)
+}
+
+func (i InstLimits) Opcode() uint8 {
+	return IHeaderLimits
+}
+
+func (i InstLimits) Operands() []int64 {
+	return []int64{int64(i.Registers), int64(i.Stack), int64(i.CallStack)}
+}
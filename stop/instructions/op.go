@@ -6,26 +6,66 @@ func (i InstAdd) Emit() []byte {
 	return []byte{IHeaderAdd}
 }
 
+func (i InstAdd) Opcode() uint8 {
+	return IHeaderAdd
+}
+
+func (i InstAdd) Operands() []int64 {
+	return nil
+}
+
 type InstSub struct{}
 
 func (i InstSub) Emit() []byte {
 	return []byte{IHeaderSub}
 }
 
+func (i InstSub) Opcode() uint8 {
+	return IHeaderSub
+}
+
+func (i InstSub) Operands() []int64 {
+	return nil
+}
+
 type InstMul struct{}
 
 func (i InstMul) Emit() []byte {
 	return []byte{IHeaderMul}
 }
 
+func (i InstMul) Opcode() uint8 {
+	return IHeaderMul
+}
+
+func (i InstMul) Operands() []int64 {
+	return nil
+}
+
 type InstDiv struct{}
 
 func (i InstDiv) Emit() []byte {
 	return []byte{IHeaderDiv}
 }
 
+func (i InstDiv) Opcode() uint8 {
+	return IHeaderDiv
+}
+
+func (i InstDiv) Operands() []int64 {
+	return nil
+}
+
 type InstMod struct{}
 
 func (i InstMod) Emit() []byte {
 	return []byte{IHeaderMod}
 }
+
+func (i InstMod) Opcode() uint8 {
+	return IHeaderMod
+}
+
+func (i InstMod) Operands() []int64 {
+	return nil
+}
@@ -9,6 +9,14 @@ func (i InstMovLiteral) Emit() []byte {
 	return append([]byte{IHeaderMovLiteral, i.Register}, i64ToBytes(i.Value)...)
 }
 
+func (i InstMovLiteral) Opcode() uint8 {
+	return IHeaderMovLiteral
+}
+
+func (i InstMovLiteral) Operands() []int64 {
+	return []int64{int64(i.Register), i.Value}
+}
+
 type InstMovRegister struct {
 	Register uint8
 	Source   uint8
@@ -18,6 +26,14 @@ func (i InstMovRegister) Emit() []byte {
 	return []byte{IHeaderMovRegister, i.Register, i.Source}
 }
 
+func (i InstMovRegister) Opcode() uint8 {
+	return IHeaderMovRegister
+}
+
+func (i InstMovRegister) Operands() []int64 {
+	return []int64{int64(i.Register), int64(i.Source)}
+}
+
 type InstLd struct {
 	Register uint8
 }
@@ -26,6 +42,14 @@ func (i InstLd) Emit() []byte {
 	return []byte{IHeaderLd, i.Register}
 }
 
+func (i InstLd) Opcode() uint8 {
+	return IHeaderLd
+}
+
+func (i InstLd) Operands() []int64 {
+	return []int64{int64(i.Register)}
+}
+
 type InstSt struct {
 	Register uint8
 }
@@ -33,3 +57,11 @@ type InstSt struct {
 func (i InstSt) Emit() []byte {
 	return []byte{IHeaderSt, i.Register}
 }
+
+func (i InstSt) Opcode() uint8 {
+	return IHeaderSt
+}
+
+func (i InstSt) Operands() []int64 {
+	return []int64{int64(i.Register)}
+}
@@ -8,20 +8,52 @@ func (i InstPush) Emit() []byte {
 	return append([]byte{IHeaderPush}, i64ToBytes(i.Value)...)
 }
 
+func (i InstPush) Opcode() uint8 {
+	return IHeaderPush
+}
+
+func (i InstPush) Operands() []int64 {
+	return []int64{i.Value}
+}
+
 type InstDup struct{}
 
 func (i InstDup) Emit() []byte {
 	return []byte{IHeaderDup}
 }
 
+func (i InstDup) Opcode() uint8 {
+	return IHeaderDup
+}
+
+func (i InstDup) Operands() []int64 {
+	return nil
+}
+
 type InstDrop struct{}
 
 func (i InstDrop) Emit() []byte {
 	return []byte{IHeaderDrop}
 }
 
+func (i InstDrop) Opcode() uint8 {
+	return IHeaderDrop
+}
+
+func (i InstDrop) Operands() []int64 {
+	return nil
+}
+
 type InstSwap struct{}
 
 func (i InstSwap) Emit() []byte {
 	return []byte{IHeaderSwap}
 }
+
+func (i InstSwap) Opcode() uint8 {
+	return IHeaderSwap
+}
+
+func (i InstSwap) Operands() []int64 {
+	return nil
+}
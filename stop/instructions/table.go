@@ -0,0 +1,374 @@
+package instructions
+
+//go:generate go run ./gen ../../csvm/instructions.h
+
+import "fmt"
+
+type OperandKind uint8
+
+const (
+	OperandRegister OperandKind = iota // {reg}
+	OperandLiteral                     // {value[8]}
+	OperandLabel                       // {label[2]}
+	OperandU16                         // {value[2]}
+	OperandU32                         // {value[4]}
+	OperandAddress                     // {addr[4]}
+)
+
+func (k OperandKind) Size() int {
+	switch k {
+	case OperandRegister:
+		return 1
+	case OperandLiteral:
+		return 8
+	case OperandLabel, OperandU16:
+		return 2
+	case OperandU32, OperandAddress:
+		return 4
+	default:
+		panic(fmt.Sprintf("unknown operand kind %d", k))
+	}
+}
+
+func (k OperandKind) String() string {
+	switch k {
+	case OperandRegister:
+		return "register"
+	case OperandLiteral:
+		return "number"
+	case OperandLabel:
+		return "label"
+	case OperandU16, OperandU32:
+		return "number"
+	case OperandAddress:
+		return "address"
+	default:
+		return fmt.Sprintf("operand(%d)", k)
+	}
+}
+
+type Spec struct {
+	Name     string
+	Mnemonic string
+	Opcode   uint8
+	Operands []OperandKind
+	Size     int
+	Pops     int
+	Pushes   int
+	Doc      string
+	Explain  string
+	Internal bool
+	Symbolic bool
+	Resolved uint8
+	Build    func(args []int64) Instruction
+}
+
+var Table = []Spec{
+	{
+		Name: "Hlt", Mnemonic: "hlt", Opcode: IHeaderHlt, Size: ISizeHlt,
+		Doc:   "Halt",
+		Build: func([]int64) Instruction { return InstHlt{} },
+	},
+	{
+		Name: "Dbg", Mnemonic: "dbg", Opcode: IHeaderDbg, Size: ISizeDbg,
+		Doc:   "Debug",
+		Build: func([]int64) Instruction { return InstDbg{} },
+	},
+	{
+		Name: "Limits", Mnemonic: "limits", Opcode: IHeaderLimits, Size: ISizeLimits,
+		Operands: []OperandKind{OperandU16, OperandU32, OperandU32},
+		Doc:      "Limits",
+		Explain:  "(%d registers, stack %d, call stack %d)",
+		Internal: true,
+		Build: func(args []int64) Instruction {
+			return InstLimits{Registers: uint16(args[0]), Stack: uint32(args[1]), CallStack: uint32(args[2])}
+		},
+	},
+	{
+		Name: "MovLiteral", Mnemonic: "mov", Opcode: IHeaderMovLiteral, Size: ISizeMovLiteral,
+		Operands: []OperandKind{OperandRegister, OperandLiteral},
+		Doc:      "Move value",
+		Explain:  "(literal %[2]d -> register %[1]d)",
+		Build: func(args []int64) Instruction {
+			return InstMovLiteral{Register: uint8(args[0]), Value: args[1]}
+		},
+	},
+	{
+		Name: "MovRegister", Mnemonic: "mov", Opcode: IHeaderMovRegister, Size: ISizeMovRegister,
+		Operands: []OperandKind{OperandRegister, OperandRegister},
+		Doc:      "Move register",
+		Explain:  "(register %[2]d -> register %[1]d)",
+		Build: func(args []int64) Instruction {
+			return InstMovRegister{Register: uint8(args[0]), Source: uint8(args[1])}
+		},
+	},
+	{
+		Name: "Push", Mnemonic: "push", Opcode: IHeaderPush, Size: ISizePush,
+		Operands: []OperandKind{OperandLiteral},
+		Pushes:   1,
+		Doc:      "Push value",
+		Explain:  "(literal %d)",
+		Build:    func(args []int64) Instruction { return InstPush{Value: args[0]} },
+	},
+	{
+		Name: "Dup", Mnemonic: "dup", Opcode: IHeaderDup, Size: ISizeDup,
+		Pops: 1, Pushes: 2,
+		Doc:   "Duplicate value",
+		Build: func([]int64) Instruction { return InstDup{} },
+	},
+	{
+		Name: "Drop", Mnemonic: "drop", Opcode: IHeaderDrop, Size: ISizeDrop,
+		Pops:  1,
+		Doc:   "Drop value",
+		Build: func([]int64) Instruction { return InstDrop{} },
+	},
+	{
+		Name: "Swap", Mnemonic: "swap", Opcode: IHeaderSwap, Size: ISizeSwap,
+		Pops: 2, Pushes: 2,
+		Doc:   "Swap values",
+		Build: func([]int64) Instruction { return InstSwap{} },
+	},
+	{
+		Name: "Ld", Mnemonic: "ld", Opcode: IHeaderLd, Size: ISizeLd,
+		Operands: []OperandKind{OperandRegister},
+		Pushes:   1,
+		Doc:      "Load value",
+		Explain:  "(register %d)",
+		Build:    func(args []int64) Instruction { return InstLd{Register: uint8(args[0])} },
+	},
+	{
+		Name: "St", Mnemonic: "st", Opcode: IHeaderSt, Size: ISizeSt,
+		Operands: []OperandKind{OperandRegister},
+		Pops:     1,
+		Doc:      "Store value",
+		Explain:  "(register %d)",
+		Build:    func(args []int64) Instruction { return InstSt{Register: uint8(args[0])} },
+	},
+	{
+		Name: "Add", Mnemonic: "add", Opcode: IHeaderAdd, Size: ISizeAdd,
+		Pops: 2, Pushes: 1,
+		Doc:   "Add",
+		Build: func([]int64) Instruction { return InstAdd{} },
+	},
+	{
+		Name: "Sub", Mnemonic: "sub", Opcode: IHeaderSub, Size: ISizeSub,
+		Pops: 2, Pushes: 1,
+		Doc:   "Subtract",
+		Build: func([]int64) Instruction { return InstSub{} },
+	},
+	{
+		Name: "Mul", Mnemonic: "mul", Opcode: IHeaderMul, Size: ISizeMul,
+		Pops: 2, Pushes: 1,
+		Doc:   "Multiply",
+		Build: func([]int64) Instruction { return InstMul{} },
+	},
+	{
+		Name: "Div", Mnemonic: "div", Opcode: IHeaderDiv, Size: ISizeDiv,
+		Pops: 2, Pushes: 1,
+		Doc:   "Divide",
+		Build: func([]int64) Instruction { return InstDiv{} },
+	},
+	{
+		Name: "Mod", Mnemonic: "mod", Opcode: IHeaderMod, Size: ISizeMod,
+		Pops: 2, Pushes: 1,
+		Doc:   "Modulo",
+		Build: func([]int64) Instruction { return InstMod{} },
+	},
+	{
+		Name: "Label", Mnemonic: "label", Opcode: IHeaderLabel, Size: ISizeLabel,
+		Operands: []OperandKind{OperandLabel},
+		Doc:      "Label",
+		Explain:  "(label %d)",
+		Internal: true,
+		Symbolic: true,
+		Build:    func(args []int64) Instruction { return InstLabel{Label: uint32(args[0])} },
+	},
+	{
+		Name: "Call", Mnemonic: "call", Opcode: IHeaderCall, Size: ISizeCall,
+		Operands: []OperandKind{OperandLabel},
+		Doc:      "Call",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderCallAddr,
+		Build:    func(args []int64) Instruction { return InstCall{Label: uint32(args[0])} },
+	},
+	{
+		Name: "Jmp", Mnemonic: "jmp", Opcode: IHeaderJmp, Size: ISizeJmp,
+		Operands: []OperandKind{OperandLabel},
+		Doc:      "Jump",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderJmpAddr,
+		Build:    func(args []int64) Instruction { return InstJmp{Label: uint32(args[0])} },
+	},
+	{
+		Name: "JmpZ", Mnemonic: "jmpz", Opcode: IHeaderJmpZ, Size: ISizeJmpZ,
+		Operands: []OperandKind{OperandLabel},
+		Pops:     1,
+		Doc:      "Jump if zero",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderJmpZAddr,
+		Build:    func(args []int64) Instruction { return InstJmpZ{Label: uint32(args[0])} },
+	},
+	{
+		Name: "JmpNZ", Mnemonic: "jmpnz", Opcode: IHeaderJmpNZ, Size: ISizeJmpNZ,
+		Operands: []OperandKind{OperandLabel},
+		Pops:     1,
+		Doc:      "Jump if not zero",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderJmpNZAddr,
+		Build:    func(args []int64) Instruction { return InstJmpNZ{Label: uint32(args[0])} },
+	},
+	{
+		Name: "JmpP", Mnemonic: "jmpp", Opcode: IHeaderJmpP, Size: ISizeJmpP,
+		Operands: []OperandKind{OperandLabel},
+		Pops:     1,
+		Doc:      "Jump if positive",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderJmpPAddr,
+		Build:    func(args []int64) Instruction { return InstJmpP{Label: uint32(args[0])} },
+	},
+	{
+		Name: "JmpN", Mnemonic: "jmpn", Opcode: IHeaderJmpN, Size: ISizeJmpN,
+		Operands: []OperandKind{OperandLabel},
+		Pops:     1,
+		Doc:      "Jump if negative",
+		Explain:  "(label %d)",
+		Symbolic: true,
+		Resolved: IHeaderJmpNAddr,
+		Build:    func(args []int64) Instruction { return InstJmpN{Label: uint32(args[0])} },
+	},
+	{
+		Name: "Ret", Mnemonic: "ret", Opcode: IHeaderRet, Size: ISizeRet,
+		Doc:   "Return",
+		Build: func([]int64) Instruction { return InstRet{} },
+	},
+	{
+		Name: "CallAddr", Mnemonic: "call", Opcode: IHeaderCallAddr, Size: ISizeCallAddr,
+		Operands: []OperandKind{OperandAddress},
+		Doc:      "Call address",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstCallAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "JmpAddr", Mnemonic: "jmp", Opcode: IHeaderJmpAddr, Size: ISizeJmpAddr,
+		Operands: []OperandKind{OperandAddress},
+		Doc:      "Jump to address",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstJmpAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "JmpZAddr", Mnemonic: "jmpz", Opcode: IHeaderJmpZAddr, Size: ISizeJmpZAddr,
+		Operands: []OperandKind{OperandAddress},
+		Pops:     1,
+		Doc:      "Jump to address if zero",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstJmpZAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "JmpNZAddr", Mnemonic: "jmpnz", Opcode: IHeaderJmpNZAddr, Size: ISizeJmpNZAddr,
+		Operands: []OperandKind{OperandAddress},
+		Pops:     1,
+		Doc:      "Jump to address if not zero",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstJmpNZAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "JmpPAddr", Mnemonic: "jmpp", Opcode: IHeaderJmpPAddr, Size: ISizeJmpPAddr,
+		Operands: []OperandKind{OperandAddress},
+		Pops:     1,
+		Doc:      "Jump to address if positive",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstJmpPAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "JmpNAddr", Mnemonic: "jmpn", Opcode: IHeaderJmpNAddr, Size: ISizeJmpNAddr,
+		Operands: []OperandKind{OperandAddress},
+		Pops:     1,
+		Doc:      "Jump to address if negative",
+		Explain:  "(address %x)",
+		Internal: true,
+		Build:    func(args []int64) Instruction { return InstJmpNAddr{Addr: uint32(args[0])} },
+	},
+	{
+		Name: "PutN", Mnemonic: "putn", Opcode: IHeaderPutN, Size: ISizePutN,
+		Pops:  1,
+		Doc:   "Put number",
+		Build: func([]int64) Instruction { return InstPutN{} },
+	},
+	{
+		Name: "PutC", Mnemonic: "putc", Opcode: IHeaderPutC, Size: ISizePutC,
+		Pops:  1,
+		Doc:   "Put character",
+		Build: func([]int64) Instruction { return InstPutC{} },
+	},
+}
+
+var (
+	byOpcode   [256]*Spec
+	byMnemonic = map[string][]*Spec{}
+)
+
+func init() {
+	for i := range Table {
+		spec := &Table[i]
+
+		size := 1
+		for _, op := range spec.Operands {
+			size += op.Size()
+		}
+
+		if size != spec.Size {
+			panic(fmt.Sprintf("instruction %s has size %d but its operands need %d", spec.Name, spec.Size, size))
+		}
+
+		if byOpcode[spec.Opcode] != nil {
+			panic(fmt.Sprintf("instructions %s and %s share opcode %02x", byOpcode[spec.Opcode].Name, spec.Name, spec.Opcode))
+		}
+
+		byOpcode[spec.Opcode] = spec
+
+		if !spec.Internal {
+			byMnemonic[spec.Mnemonic] = append(byMnemonic[spec.Mnemonic], spec)
+		}
+	}
+}
+
+func Lookup(opcode uint8) (*Spec, bool) {
+	spec := byOpcode[opcode]
+	return spec, spec != nil
+}
+
+func LookupMnemonic(mnemonic string) []*Spec {
+	return byMnemonic[mnemonic]
+}
+
+func ReadOperands(spec *Spec, code []byte) []int64 {
+	args := make([]int64, len(spec.Operands))
+
+	offset := 1
+	for i, op := range spec.Operands {
+		switch op {
+		case OperandRegister:
+			args[i] = int64(code[offset])
+		case OperandLiteral:
+			args[i] = bytesToI64(code[offset:])
+		case OperandLabel, OperandU16:
+			args[i] = int64(bytesToU16(code[offset:]))
+		case OperandU32, OperandAddress:
+			args[i] = int64(bytesToU32(code[offset:]))
+		}
+
+		offset += op.Size()
+	}
+
+	return args
+}
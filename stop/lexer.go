@@ -0,0 +1,201 @@
+package stop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenWord     tokenKind = iota // mnemonics, operands, directives and names
+	tokenString                    // "quoted", with escapes decoded into value
+	tokenChar                      // 'c', with its escape decoded into value
+	tokenColon                     // ':' starting a label
+	tokenOpen                      // '('
+	tokenClose                     // ')'
+	tokenOperator                  // arithmetic in a constant expression
+)
+
+// token is a piece of source and the byte offset in its line it starts at.
+// text is exactly as written; value is the decoded contents of a string or
+// character.
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	col   int
+}
+
+// tokenError is a parse error about a particular token.
+type tokenError struct {
+	tok token
+	msg string
+}
+
+func (e *tokenError) Error() string {
+	return e.msg
+}
+
+func errorAt(tok token, format string, args ...any) error {
+	return &tokenError{tok: tok, msg: fmt.Sprintf(format, args...)}
+}
+
+// spanning returns a token covering toks from the first to the last.
+func spanning(line string, toks []token) token {
+	first, last := toks[0], toks[len(toks)-1]
+	return token{text: line[first.col : last.col+len(last.text)], col: first.col}
+}
+
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// lexLine splits a line of source into tokens. Tokens are separated by any
+// amount of whitespace and a ';' outside a string comments out the rest of
+// the line.
+func lexLine(line string) ([]token, error) {
+	toks := []token{}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+
+		switch {
+		case isSpace(c):
+			i++
+		case c == ';':
+			return toks, nil
+		case c == '(' || c == ')':
+			kind := tokenOpen
+			if c == ')' {
+				kind = tokenClose
+			}
+
+			toks = append(toks, token{kind: kind, text: line[i : i+1], col: i})
+			i++
+		case c == ':' && (i == 0 || isSpace(line[i-1])):
+			toks = append(toks, token{kind: tokenColon, text: ":", col: i})
+			i++
+		case c == '"':
+			tok, err := lexQuoted(line, i)
+			if err != nil {
+				return nil, err
+			}
+
+			toks = append(toks, tok)
+			i += len(tok.text)
+		default:
+			// A character literal may be part of a word, as in 'A'+1, and
+			// can hold the characters that otherwise end one.
+			start := i
+			var char token
+			for i < len(line) && !isSpace(line[i]) && !strings.ContainsRune(";()\"", rune(line[i])) {
+				if line[i] != '\'' {
+					i++
+					continue
+				}
+
+				var err error
+				if char, err = lexQuoted(line, i); err != nil {
+					return nil, err
+				}
+
+				i += len(char.text)
+			}
+
+			tok := token{kind: tokenWord, text: line[start:i], col: start}
+			if tok.text == char.text {
+				tok = char
+			}
+
+			toks = append(toks, tok)
+		}
+	}
+
+	return toks, nil
+}
+
+// lexQuoted reads a string or character literal, whichever the quote at
+// start opens.
+func lexQuoted(line string, start int) (token, error) {
+	quote := line[start]
+	value := []byte{}
+
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case quote:
+			tok := token{kind: tokenString, text: line[start : i+1], value: string(value), col: start}
+			if quote == '"' {
+				return tok, nil
+			}
+
+			tok.kind = tokenChar
+			if utf8.RuneCountInString(tok.value) != 1 {
+				return token{}, errorAt(tok, "character literal must contain exactly one character")
+			}
+
+			return tok, nil
+		case '\\':
+			if i+1 == len(line) {
+				break
+			}
+
+			if line[i+1] == 'u' {
+				r, n, err := lexUnicode(line, i)
+				if err != nil {
+					return token{}, err
+				}
+
+				value = utf8.AppendRune(value, r)
+				i += n - 1
+				continue
+			}
+
+			c, ok := escapes[line[i+1]]
+			if !ok {
+				return token{}, errorAt(token{text: line[i : i+2], col: i}, "unknown escape sequence \\%c", line[i+1])
+			}
+
+			value = append(value, c)
+			i++
+		default:
+			value = append(value, line[i])
+		}
+	}
+
+	if quote == '"' {
+		return token{}, errorAt(token{text: line[start:], col: start}, "unterminated string")
+	}
+
+	return token{}, errorAt(token{text: line[start:], col: start}, "unterminated character literal")
+}
+
+// lexUnicode reads a \u{X} escape at i, returning the code point it names and
+// the length of the escape.
+func lexUnicode(line string, i int) (rune, int, error) {
+	end := strings.IndexByte(line[i:], '}')
+	if !strings.HasPrefix(line[i:], `\u{`) || end < 0 {
+		return 0, 0, errorAt(token{text: line[i : i+2], col: i}, "unicode escape must be written as \\u{hex digits}")
+	}
+
+	escape := token{text: line[i : i+end+1], col: i}
+
+	v, err := strconv.ParseUint(line[i+3:i+end], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, 0, errorAt(escape, "%s is not a valid unicode code point", escape.text)
+	}
+
+	return rune(v), len(escape.text), nil
+}
@@ -0,0 +1,52 @@
+package stop
+
+import "fmt"
+
+type Limits struct {
+	Registers     int
+	StackSize     int
+	CallStackSize int
+}
+
+// MAX_STACK_SIZE bounds both stacks, so a program cannot ask for more memory
+// than a VM can sensibly allocate.
+const MAX_STACK_SIZE int = 1 << 24
+
+var DefaultLimits = Limits{
+	Registers:     REGISTER_COUNT,
+	StackSize:     STACK_SIZE,
+	CallStackSize: CALL_STACK_SIZE,
+}
+
+func (l Limits) Validate() error {
+	if l.Registers < 1 || l.Registers > 256 {
+		return fmt.Errorf("register count must be between 1 and 256, got %d", l.Registers)
+	}
+
+	if l.StackSize < 1 || l.StackSize > MAX_STACK_SIZE {
+		return fmt.Errorf("stack size must be between 1 and %d, got %d", MAX_STACK_SIZE, l.StackSize)
+	}
+
+	if l.CallStackSize < 1 || l.CallStackSize > MAX_STACK_SIZE {
+		return fmt.Errorf("call stack size must be between 1 and %d, got %d", MAX_STACK_SIZE, l.CallStackSize)
+	}
+
+	return nil
+}
+
+// within reports the first of l's sizes that is larger than max allows.
+func (l Limits) within(max Limits) error {
+	if l.Registers > max.Registers {
+		return fmt.Errorf("program asks for %d registers but at most %d are allowed", l.Registers, max.Registers)
+	}
+
+	if l.StackSize > max.StackSize {
+		return fmt.Errorf("program asks for a stack size of %d but at most %d is allowed", l.StackSize, max.StackSize)
+	}
+
+	if l.CallStackSize > max.CallStackSize {
+		return fmt.Errorf("program asks for a call stack size of %d but at most %d is allowed", l.CallStackSize, max.CallStackSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package stop
+
+import (
+	"fmt"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+type linked struct {
+	insts   []instructions.Instruction
+	offsets []int
+	labels  map[uint32]int
+}
+
+// link lays out symbolic instructions, resolving label references to
+// absolute code offsets and dropping the labels themselves. offsets holds the
+// linked offset of every input instruction; a label maps to the instruction
+// that follows it.
+func link(insts []instructions.Instruction) (*linked, error) {
+	l := &linked{
+		offsets: make([]int, len(insts)),
+		labels:  map[uint32]int{},
+	}
+
+	offset := 0
+	for i, inst := range insts {
+		l.offsets[i] = offset
+
+		if label, ok := inst.(instructions.InstLabel); ok {
+			if _, ok := l.labels[label.Label]; ok {
+				return nil, fmt.Errorf("label %d defined more than once", label.Label)
+			}
+
+			l.labels[label.Label] = offset
+			continue
+		}
+
+		spec, _ := instructions.Lookup(inst.Opcode())
+		if spec.Resolved != 0 {
+			spec, _ = instructions.Lookup(spec.Resolved)
+		}
+
+		offset += spec.Size
+	}
+
+	for i, inst := range insts {
+		spec, _ := instructions.Lookup(inst.Opcode())
+
+		if !spec.Symbolic {
+			l.insts = append(l.insts, inst)
+			continue
+		}
+
+		if spec.Resolved == 0 {
+			continue
+		}
+
+		label := uint32(inst.Operands()[0])
+
+		target, ok := l.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("%s at instruction %d refers to undefined label %d", spec.Mnemonic, i, label)
+		}
+
+		resolved, _ := instructions.Lookup(spec.Resolved)
+		l.insts = append(l.insts, resolved.Build([]int64{int64(target)}))
+	}
+
+	return l, nil
+}
+
+func emit(insts []instructions.Instruction) []byte {
+	out := []byte{}
+
+	for _, inst := range insts {
+		out = append(out, inst.Emit()...)
+	}
+
+	return out
+}
+
+type loaded struct {
+	code  []byte
+	entry int
+	debug *DebugInfo
+}
+
+// loadCode returns the linked code of a container. Legacy code still carries
+// label opcodes, so it is linked here.
+func loadCode(c *Container) (*loaded, error) {
+	code := c.Code()
+
+	if c.Version != LEGACY_VERSION {
+		var debug *DebugInfo
+		if section, ok := c.Section(SectionDebug); ok {
+			var err error
+			if debug, err = DecodeDebugInfo(section); err != nil {
+				return nil, err
+			}
+		}
+
+		return &loaded{code: append([]byte{}, code...), entry: int(c.Entry), debug: debug}, nil
+	}
+
+	if err := problems(verifySymbolic(code), func(int) string { return "" }); err != nil {
+		return nil, err
+	}
+
+	insts, _ := instructions.Decode(code)
+	l, _ := link(insts)
+
+	return &loaded{code: emit(l.insts)}, nil
+}
@@ -0,0 +1,286 @@
+package stop
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+// macro is a .macro definition. Labels defined in its body are local: each
+// expansion renames them, and references to them, so a macro can be used
+// more than once.
+type macro struct {
+	name   string
+	params []string
+	def    *sourceLine
+	body   []*sourceLine
+	locals map[string]bool
+}
+
+func parseMacro(s *sourceLine, macros map[string]*macro) (*macro, error) {
+	toks := s.toks
+	m := &macro{def: s, locals: map[string]bool{}}
+
+	if len(toks) < 2 {
+		return m, errorAt(toks[0], ".macro must have a name")
+	}
+
+	name := toks[1]
+
+	switch {
+	case name.kind != tokenWord || !isIdent(name.text):
+		return m, errorAt(name, "macro name must be a valid identifier ([A-Za-z_][A-Za-z0-9_]*)")
+	case len(instructions.LookupMnemonic(name.text)) > 0 || name.text == "prints":
+		return m, errorAt(name, "macro %s would hide the instruction of the same name", name.text)
+	case macros[name.text] != nil:
+		return m, errorAt(name, "macro %s already defined on line %d", name.text, macros[name.text].def.line)
+	}
+
+	m.name = name.text
+
+	for _, param := range toks[2:] {
+		if ok, _ := isReg(param.text); ok || param.kind != tokenWord || !isIdent(param.text) {
+			return m, errorAt(param, "macro parameter must be a valid identifier ([A-Za-z_][A-Za-z0-9_]*)")
+		}
+
+		for _, seen := range m.params {
+			if seen == param.text {
+				return m, errorAt(param, "macro parameter %s appears more than once", param.text)
+			}
+		}
+
+		m.params = append(m.params, param.text)
+	}
+
+	return m, nil
+}
+
+// macroArgs splits the operands of a macro invocation into its arguments. An
+// argument is one token or a parenthesised group, except that the last takes
+// the rest of the line, as an instruction's literal does.
+func macroArgs(s *sourceLine, m *macro) ([]string, error) {
+	groups := [][]token{}
+	starts := []int{}
+	depth := 0
+	start := 1
+
+	for i := 1; i < len(s.toks); i++ {
+		switch s.toks[i].kind {
+		case tokenOpen:
+			depth++
+		case tokenClose:
+			depth--
+		}
+
+		if depth < 0 {
+			return nil, errorAt(s.toks[i], "unexpected )")
+		}
+
+		if depth == 0 {
+			groups = append(groups, s.toks[start:i+1])
+			starts = append(starts, start)
+			start = i + 1
+		}
+	}
+
+	if depth > 0 {
+		return nil, errorAt(s.toks[start], "( is never closed")
+	}
+
+	if n := len(m.params); n > 0 && len(groups) > n {
+		groups = append(groups[:n-1:n-1], s.toks[starts[n-1]:])
+	}
+
+	if len(groups) != len(m.params) {
+		return nil, errorAt(spanning(s.text, s.toks), "macro %s takes %s but was given %d", m.name, plural(len(m.params), "argument"), len(groups))
+	}
+
+	args := []string{}
+	for _, group := range groups {
+		args = append(args, spanning(s.text, group).text)
+	}
+
+	return args, nil
+}
+
+// substitute rewrites a line of a macro body, replacing each name in
+// replacements wherever it appears outside directives and string and
+// character literals.
+func substitute(s *sourceLine, replacements map[string]string) string {
+	out := &strings.Builder{}
+	last := 0
+
+	for n, tok := range s.toks {
+		if tok.kind != tokenWord || n == 0 && tok.text[0] == '.' {
+			continue
+		}
+
+		for i := tok.col; i < tok.col+len(tok.text); {
+			c := s.text[i]
+
+			if c == '\'' {
+				char, _ := lexQuoted(s.text, i)
+				i += max(len(char.text), 1)
+				continue
+			}
+
+			if !isNameChar(c) {
+				i++
+				continue
+			}
+
+			start := i
+			for i < tok.col+len(tok.text) && isNameChar(s.text[i]) {
+				i++
+			}
+
+			// Numbers are not renamed.
+			if !isNameStart(c) {
+				continue
+			}
+
+			if with, ok := replacements[s.text[start:i]]; ok {
+				out.WriteString(s.text[last:start])
+				out.WriteString(with)
+				last = i
+			}
+		}
+	}
+
+	out.WriteString(s.text[last:])
+	return out.String()
+}
+
+// expandMacros removes .macro definitions from src and replaces each
+// invocation with the macro's body. Macros may invoke other macros, but not
+// themselves.
+func expandMacros(src []*sourceLine, fail func(*sourceLine, error)) []*sourceLine {
+	macros := map[string]*macro{}
+	rest := []*sourceLine{}
+
+	// current is the macro being defined, if any, and valid is whether its
+	// definition line was, since a broken one still has a body to skip.
+	var current *macro
+	valid := false
+
+	for _, s := range src {
+		directive := ""
+		if len(s.toks) > 0 && s.toks[0].kind == tokenWord {
+			directive = s.toks[0].text
+		}
+
+		switch {
+		case directive == ".macro":
+			if current != nil {
+				fail(s, errorAt(s.toks[0], "macros cannot be defined inside another macro"))
+				continue
+			}
+
+			m, err := parseMacro(s, macros)
+			if err != nil {
+				fail(s, err)
+			}
+
+			current, valid = m, err == nil
+		case directive == ".endm":
+			if current == nil {
+				fail(s, errorAt(s.toks[0], ".endm without .macro"))
+				continue
+			}
+
+			if len(s.toks) > 1 {
+				fail(s, errorAt(spanning(s.text, s.toks[1:]), "unexpected %s after .endm", s.toks[1].text))
+			}
+
+			if valid {
+				macros[current.name] = current
+			}
+
+			current = nil
+		case current != nil:
+			if len(s.toks) > 1 && s.toks[0].kind == tokenColon {
+				current.locals[strings.TrimPrefix(s.toks[1].text, ".")] = true
+			}
+
+			current.body = append(current.body, s)
+		default:
+			rest = append(rest, s)
+		}
+	}
+
+	if current != nil {
+		def := current.def
+		fail(def, errorAt(def.toks[0], "%s is never closed with .endm", spanning(def.text, def.toks[:min(len(def.toks), 2)]).text))
+	}
+
+	expansions := 0
+
+	var expand func(lines []*sourceLine) []*sourceLine
+	expand = func(lines []*sourceLine) []*sourceLine {
+		out := []*sourceLine{}
+
+		for _, s := range lines {
+			if len(s.toks) == 0 || s.toks[0].kind != tokenWord || macros[s.toks[0].text] == nil {
+				out = append(out, s)
+				continue
+			}
+
+			m := macros[s.toks[0].text]
+
+			recursive := false
+			for e := s.expansion; e != nil; e = e.at.expansion {
+				recursive = recursive || e.name == m.name
+			}
+
+			if recursive {
+				fail(s, errorAt(s.toks[0], "macro %s expands itself", m.name))
+				continue
+			}
+
+			args, err := macroArgs(s, m)
+			if err != nil {
+				fail(s, err)
+				continue
+			}
+
+			expansions++
+			e := &expansion{name: m.name, id: expansions, at: s}
+
+			replacements := map[string]string{}
+			for label := range m.locals {
+				replacements[label] = fmt.Sprintf("%s@%d", label, e.id)
+			}
+			for i, param := range m.params {
+				replacements[param] = args[i]
+			}
+
+			body := []*sourceLine{}
+			for _, line := range m.body {
+				expanded := &sourceLine{file: line.file, line: line.line, text: substitute(line, replacements), expansion: e}
+
+				toks, err := lexLine(expanded.text)
+				if err != nil {
+					fail(expanded, err)
+				}
+
+				expanded.toks = toks
+				body = append(body, expanded)
+			}
+
+			out = append(out, expand(body)...)
+		}
+
+		return out
+	}
+
+	return expand(rest)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
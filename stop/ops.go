@@ -0,0 +1,59 @@
+package stop
+
+import "github.com/vcokltfre/stop/stop/instructions"
+
+// op is a pre-decoded instruction. Operands are unpacked once at load time
+// and jump targets are indices into the op slice rather than code offsets.
+type op struct {
+	exec   func(*VM, *op) error
+	a, b   int
+	val    int64
+	target int
+	pc     int
+}
+
+// decodeOps turns verified code into ops. A final op marks the end of the
+// code so that running off the end, or jumping to it, halts like hlt does.
+// index maps each instruction's code offset to its op.
+func decodeOps(code []byte) (ops []op, index map[int]int) {
+	index = map[int]int{}
+	jumps := []int{}
+
+	for pc := 0; pc < len(code); {
+		inst, size, _ := instructions.DecodeAt(code, pc)
+		spec, _ := instructions.Lookup(inst.Opcode())
+
+		o := op{exec: handlers[spec.Opcode], pc: pc}
+		regs := 0
+
+		for i, arg := range inst.Operands() {
+			switch spec.Operands[i] {
+			case instructions.OperandRegister:
+				if regs == 0 {
+					o.a = int(arg)
+				} else {
+					o.b = int(arg)
+				}
+				regs++
+			case instructions.OperandLiteral:
+				o.val = arg
+			case instructions.OperandAddress:
+				o.target = int(arg)
+				jumps = append(jumps, len(ops))
+			}
+		}
+
+		index[pc] = len(ops)
+		ops = append(ops, o)
+		pc += size
+	}
+
+	index[len(code)] = len(ops)
+	ops = append(ops, op{exec: (*VM).instEnd, pc: len(code)})
+
+	for _, i := range jumps {
+		ops[i].target = index[ops[i].target]
+	}
+
+	return ops, index
+}
@@ -2,15 +2,24 @@ package stop
 
 import (
 	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/vcokltfre/stop/stop/instructions"
 )
 
 func isIdent(val string) bool {
-	for _, r := range val {
-		if !(r >= 'a' && r <= 'z') {
+	if val == "" || !isNameStart(val[0]) {
+		return false
+	}
+
+	for i := 1; i < len(val); i++ {
+		if !isNameChar(val[i]) {
 			return false
 		}
 	}
@@ -19,7 +28,7 @@ func isIdent(val string) bool {
 }
 
 func isReg(val string) (bool, int) {
-	if len(val) < 2 {
+	if len(val) < 2 || len(val) > 4 {
 		return false, 0
 	}
 
@@ -27,311 +36,765 @@ func isReg(val string) (bool, int) {
 		return false, 0
 	}
 
-	if len(val) == 2 {
-		return val[1] >= '0' && val[1] <= '9', int(val[1] - '0')
+	for _, r := range val[1:] {
+		if !(r >= '0' && r <= '9') {
+			return false, 0
+		}
 	}
 
-	if len(val) == 3 {
-		n := val[1:2]
-		v, err := strconv.Atoi(n)
-		if err != nil {
-			return false, 0
+	v, err := strconv.Atoi(val[1:])
+	if err != nil {
+		return false, 0
+	}
+
+	return true, v
+}
+
+// parseNumber reads an integer written in decimal or, with a 0x, 0b or 0o
+// prefix, in hex, binary or octal. Underscores may separate digits.
+func parseNumber(text string) (int64, error) {
+	digits := strings.TrimLeft(text, "+-")
+	sign := text[:len(text)-len(digits)]
+
+	if len(sign) > 1 || digits == "" {
+		return 0, strconv.ErrSyntax
+	}
+
+	// Base 0 would read a leading zero as octal, so decimal numbers have
+	// theirs removed first.
+	if len(digits) < 2 || !strings.ContainsRune("xXbBoO", rune(digits[1])) {
+		digits = strings.TrimLeft(digits, "0")
+		if digits == "" || digits[0] == '_' {
+			digits = "0" + digits
 		}
+	}
 
-		return v <= 15, v
+	return strconv.ParseInt(sign+digits, 0, 64)
+}
+
+// isLiteral folds val as a constant expression. A register is never a
+// literal, so that instructions taking either can tell them apart.
+func isLiteral(val token, consts map[string]int64) (bool, int64, error) {
+	if ok, _ := isReg(val.text); ok || val.kind == tokenString {
+		return false, 0, nil
+	}
+
+	v, err := evalExpr(val.text, token{text: val.text}, consts)
+	if err != nil {
+		if te, ok := err.(*tokenError); ok {
+			te.tok.col += val.col
+		}
+
+		return false, 0, err
 	}
 
-	return false, 0
+	return true, v, nil
+}
+
+var argumentCounts = []string{"no arguments", "one argument", "two arguments", "three arguments"}
+
+var argumentOrdinals = []string{"first", "second", "third"}
+
+// labelKey identifies a label: the namespace of the file defining it, empty
+// for the main file, the global label and, for a local label, its own name.
+type labelKey struct {
+	namespace string
+	global    string
+	local     string
+}
+
+func (k labelKey) String() string {
+	parts := []string{}
+	for _, part := range []string{k.namespace, k.global, k.local} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func (k labelKey) describe() string {
+	switch {
+	case k.local != "" && k.namespace != "":
+		return fmt.Sprintf("local label .%s of %s in %s", k.local, k.global, k.namespace)
+	case k.local != "":
+		return fmt.Sprintf("local label .%s of %s", k.local, k.global)
+	case k.namespace != "":
+		return fmt.Sprintf("label %s in %s", k.global, k.namespace)
+	default:
+		return "label " + k.global
+	}
 }
 
-func isLiteral(val string) (bool, int64) {
-	v, err := strconv.ParseInt(val, 10, 64)
-	return err == nil, v
+// symbols are the names an operand can refer to. aliases are the register
+// names declared with .reg so far, scope is the namespace of the line being
+// parsed and global the label its local labels belong to.
+type symbols struct {
+	labels  map[labelKey]int
+	consts  map[string]int64
+	aliases map[string]int
+	scope   string
+	global  string
 }
 
-func cleanArray(arr []string) []string {
-	out := []string{}
+// label resolves a reference to a label. Each group of candidates is tried
+// in turn, so a file's own labels hide those of the main file, but a
+// reference matching two candidates in one group is ambiguous.
+func (s symbols) label(ref token) (int, bool, error) {
+	parts := strings.Split(ref.text, ".")
+
+	groups := [][]labelKey{}
+	switch {
+	case len(parts) == 1:
+		groups = [][]labelKey{{{s.scope, parts[0], ""}}, {{"", parts[0], ""}}}
+	case len(parts) == 2 && parts[0] == "":
+		if s.global == "" {
+			return 0, false, errorAt(ref, "local label %s is not under any label", ref.text)
+		}
+
+		groups = [][]labelKey{{{s.scope, s.global, parts[1]}}}
+	case len(parts) == 2:
+		groups = [][]labelKey{{{s.scope, parts[0], parts[1]}, {parts[0], parts[1], ""}}, {{"", parts[0], parts[1]}}}
+	case len(parts) == 3:
+		groups = [][]labelKey{{{parts[0], parts[1], parts[2]}}}
+	}
 
-	for _, val := range arr {
-		val = strings.TrimSpace(val)
+	for _, group := range groups {
+		found := []labelKey{}
+		for _, key := range group {
+			if _, ok := s.labels[key]; ok && !slices.Contains(found, key) {
+				found = append(found, key)
+			}
+		}
 
-		if len(val) == 0 {
+		switch len(found) {
+		case 0:
 			continue
+		case 1:
+			return s.labels[found[0]], true, nil
+		default:
+			return 0, false, errorAt(ref, "%s is ambiguous: it could be the %s or the %s", ref.text, found[0].describe(), found[1].describe())
+		}
+	}
+
+	return 0, false, nil
+}
+
+func parseOperand(kind instructions.OperandKind, val token, syms symbols, config parseConfig) (bool, int64, error) {
+	switch kind {
+	case instructions.OperandRegister:
+		ok, reg := isReg(val.text)
+		if alias, found := syms.aliases[val.text]; found && !ok {
+			ok, reg = true, alias
+		}
+
+		if ok && reg >= config.limits.Registers {
+			return false, 0, errorAt(val, "register r%d is out of range (%d registers configured)", reg, config.limits.Registers)
 		}
 
-		out = append(out, val)
+		return ok, int64(reg), nil
+	case instructions.OperandLiteral:
+		if _, ok := syms.aliases[val.text]; ok {
+			return false, 0, nil
+		}
+
+		return isLiteral(val, syms.consts)
+	case instructions.OperandLabel:
+		v, ok, err := syms.label(val)
+		return ok, int64(v), err
+	default:
+		return false, 0, nil
+	}
+}
+
+// operandsFor groups an instruction's operands for spec. A literal in the
+// last position is an expression that runs to the end of the line.
+func operandsFor(spec *instructions.Spec, line string, operands []token) []token {
+	n := len(spec.Operands)
+	if n == 0 || len(operands) <= n || spec.Operands[n-1] != instructions.OperandLiteral {
+		return operands
 	}
 
-	return out
+	return append(operands[:n-1:n-1], spanning(line, operands[n-1:]))
 }
 
-func Parse(code string) ([]instructions.Instruction, error) {
-	lines := strings.Split(code, "\n")
-	jumps := map[string]int{}
+func parseInstruction(line string, toks []token, syms symbols, config parseConfig) (instructions.Instruction, error) {
+	mnemonic, operands := toks[0], toks[1:]
+
+	specs := instructions.LookupMnemonic(mnemonic.text)
+	if len(specs) == 0 {
+		return nil, errorAt(mnemonic, "unknown instruction %s", mnemonic.text)
+	}
 
-	insts := []instructions.Instruction{}
+	failedAt := -1
+	expected := []string{}
 
-	for i, line := range lines {
-		clean := strings.TrimSpace(line)
+	// detail is a more specific reason the operand at failedAt was rejected,
+	// such as a malformed expression, used if no form of the instruction fits.
+	var detail error
+	var failedOn token
 
-		if len(clean) == 0 {
+	for _, spec := range specs {
+		grouped := operandsFor(spec, line, operands)
+		if len(spec.Operands) != len(grouped) {
 			continue
 		}
 
-		if clean[0] == ':' {
-			err := func(msg string) error {
-				return fmt.Errorf("error on line %d: %s", i+1, msg)
-			}
+		args := make([]int64, len(grouped))
+		failed := -1
 
-			if len(jumps) >= (1 << 16) {
-				return nil, err("too many labels")
+		var err error
+		for i, kind := range spec.Operands {
+			var ok bool
+			if ok, args[i], err = parseOperand(kind, grouped[i], syms, config); !ok {
+				failed = i
+				break
 			}
+		}
 
-			label := strings.TrimSpace(clean[1:])
-			if len(label) == 0 {
-				return nil, err("label must have a name")
-			}
+		if failed == -1 {
+			return spec.Build(args), nil
+		}
 
-			if !isIdent(label) {
-				return nil, err("label must be a valid identifier ([a-z]+)")
-			}
+		if failed > failedAt {
+			failedAt = failed
+			failedOn = grouped[failed]
+			expected = []string{}
+			detail = nil
+		}
 
-			if _, ok := jumps[label]; ok {
-				return nil, err("label already defined")
-			}
+		if failed == failedAt && detail == nil {
+			detail = err
+		}
+
+		if name := "a " + spec.Operands[failed].String(); failed == failedAt && !slices.Contains(expected, name) {
+			expected = append(expected, name)
+		}
+	}
+
+	if failedAt == -1 {
+		return nil, errorAt(spanning(line, toks), "%s must have %s", mnemonic.text, argumentCounts[len(specs[0].Operands)])
+	}
+
+	if detail != nil {
+		return nil, detail
+	}
+
+	position := "argument"
+	if len(specs[0].Operands) > 1 {
+		position = argumentOrdinals[failedAt] + " argument"
+	}
+
+	return nil, errorAt(failedOn, "%s %s must be %s", mnemonic.text, position, strings.Join(expected, " or "))
+}
+
+// parsePrints expands the prints pseudo-instruction into a push and putc for
+// each character of its string.
+func parsePrints(line string, toks []token) ([]instructions.Instruction, error) {
+	if len(toks) != 2 {
+		return nil, errorAt(spanning(line, toks), "prints must have one argument")
+	}
+
+	text := toks[1]
+	if text.kind != tokenString {
+		return nil, errorAt(text, "prints argument must be a string")
+	}
+
+	if !utf8.ValidString(text.value) {
+		return nil, errorAt(text, "prints argument must be valid UTF-8")
+	}
+
+	out := []instructions.Instruction{}
+	for _, r := range text.value {
+		out = append(out, instructions.InstPush{Value: int64(r)}, instructions.InstPutC{})
+	}
+
+	return out, nil
+}
+
+func parseLimits(line string, toks []token) (Limits, error) {
+	args := toks[1:]
+	if len(args) != 3 {
+		return Limits{}, errorAt(spanning(line, toks), ".limits must have three arguments (registers, stack size, call stack size)")
+	}
+
+	values := [3]int{}
+	for i, arg := range args {
+		v, err := parseNumber(arg.text)
+		if err != nil || arg.kind != tokenWord || int64(int(v)) != v {
+			return Limits{}, errorAt(arg, ".limits %s argument must be a number", argumentOrdinals[i])
+		}
+
+		values[i] = int(v)
+	}
+
+	limits := Limits{Registers: values[0], StackSize: values[1], CallStackSize: values[2]}
+
+	if err := limits.Validate(); err != nil {
+		return Limits{}, errorAt(spanning(line, toks), "%s", err)
+	}
+
+	return limits, nil
+}
+
+// parseLabel splits a label line into its name and optional stack effect
+// signature, as in ":dec ( n -- n )".
+func parseLabel(line string, toks []token) (token, *Signature, error) {
+	if len(toks) < 2 || toks[1].kind != tokenWord {
+		return token{}, nil, errorAt(toks[0], "label must have a name")
+	}
+
+	name, rest := toks[1], toks[2:]
+	if len(rest) == 0 {
+		return name, nil, nil
+	}
+
+	if rest[0].kind != tokenOpen {
+		return name, nil, errorAt(rest[0], "unexpected %s after label", rest[0].text)
+	}
+
+	malformed := errorAt(spanning(line, rest), "stack effect must be written as ( inputs -- outputs )")
+
+	closing := slices.IndexFunc(rest, func(t token) bool { return t.kind == tokenClose })
+	if closing < 0 {
+		return name, nil, malformed
+	}
+
+	if closing != len(rest)-1 {
+		extra := rest[closing+1]
+		return name, nil, errorAt(extra, "unexpected %s after label", extra.text)
+	}
+
+	fields := []string{}
+	for _, t := range rest[1:closing] {
+		if t.kind != tokenWord {
+			return name, nil, malformed
+		}
+
+		fields = append(fields, t.text)
+	}
+
+	split := slices.Index(fields, "--")
+	if split < 0 || slices.Contains(fields[split+1:], "--") {
+		return name, nil, malformed
+	}
+
+	return name, &Signature{Inputs: slices.Clip(fields[:split]), Outputs: fields[split+1:]}, nil
+}
+
+type ParseOption func(*parseConfig)
+
+type parseConfig struct {
+	limits   Limits
+	filename string
+	include  []string
+}
+
+func WithFilename(filename string) ParseOption {
+	return func(c *parseConfig) {
+		c.filename = filename
+	}
+}
+
+// WithIncludePaths adds directories to search for included files that are
+// not found relative to the file including them.
+func WithIncludePaths(paths ...string) ParseOption {
+	return func(c *parseConfig) {
+		c.include = append(c.include, paths...)
+	}
+}
+
+func WithLimits(limits Limits) ParseOption {
+	return func(c *parseConfig) {
+		c.limits = limits
+	}
+}
+
+func Parse(code string, opts ...ParseOption) ([]instructions.Instruction, error) {
+	unit, err := ParseUnit(code, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return unit.Instructions, nil
+}
+
+// ParseUnit parses a whole source file. It carries on past errors, and if
+// there were any returns them all as Diagnostics.
+func ParseUnit(code string, opts ...ParseOption) (*Unit, error) {
+	config := parseConfig{limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if err := config.limits.Validate(); err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(code, "\n")
+	jumps := map[labelKey]int{}
+	labelLines := map[*sourceLine]int{}
+
+	unit := &Unit{File: config.filename, source: map[string][]string{}}
+	diags := Diagnostics{}
+
+	fail := func(s *sourceLine, err error) {
+		diags = append(diags, s.diagnostic(err))
+	}
+
+	warn := func(s *sourceLine, err error) {
+		d := s.diagnostic(err)
+		d.Severity = SeverityWarning
+		diags = append(diags, d)
+	}
+
+	in := &includer{
+		paths:      config.include,
+		unit:       unit,
+		fail:       fail,
+		namespaces: map[string]string{},
+		owners:     map[string]string{},
+		order:      map[string]int{},
+		seen:       map[string]bool{},
+	}
+
+	abs := ""
+	if config.filename != "" {
+		abs, _ = filepath.Abs(config.filename)
+	}
+
+	limitsAt := &sourceLine{file: config.filename, line: 1, text: lines[0]}
+
+	// Each line is lexed once up front; a line that fails to lex is reported
+	// here and treated as empty by every pass.
+	src := in.load(config.filename, abs, lines, "")
+
+	src = expandMacros(src, fail)
+
+	emit := func(inst instructions.Instruction, s *sourceLine) {
+		unit.Instructions = append(unit.Instructions, inst)
+		unit.Positions = append(unit.Positions, s.position())
+	}
+
+	explicitLimits := false
+	seenCode := false
+
+	for _, s := range src {
+		toks := s.toks
+
+		if len(toks) == 0 {
+			continue
+		}
 
-			labelId := len(jumps)
-			jumps[label] = labelId
+		if toks[0].text != ".limits" {
+			seenCode = seenCode || (toks[0].text != ".const" && toks[0].text != ".reg")
+			continue
+		}
 
+		limits, err := parseLimits(s.text, toks)
+		if err == nil && explicitLimits {
+			err = errorAt(toks[0], ".limits may only appear once")
+		}
+		if err == nil && seenCode {
+			err = errorAt(toks[0], ".limits must appear before any instructions or labels")
+		}
+		if err != nil {
+			fail(s, err)
 			continue
 		}
+
+		config.limits = limits
+		explicitLimits = true
+		limitsAt = s
+	}
+
+	if explicitLimits || config.limits != DefaultLimits {
+		emit(instructions.InstLimits{
+			Registers: uint16(config.limits.Registers),
+			Stack:     uint32(config.limits.StackSize),
+			CallStack: uint32(config.limits.CallStackSize),
+		}, limitsAt)
 	}
 
-	for i, line := range lines {
-		err := func(msg string) error {
-			return fmt.Errorf("error on line %d: %s", i+1, msg)
+	// Constants are defined in order, so a .const may only use the ones
+	// before it, but instructions may use any.
+	consts := map[string]int64{}
+	constDefs := map[string]*sourceLine{}
+
+	for _, s := range src {
+		toks := s.toks
+
+		if len(toks) == 0 || toks[0].text != ".const" {
+			continue
 		}
 
-		isJump := func(loc string) (bool, uint16) {
-			v, ok := jumps[loc]
-			return ok, uint16(v)
+		if len(toks) < 3 {
+			fail(s, errorAt(spanning(s.text, toks), ".const must have a name and a value"))
+			continue
 		}
 
-		clean := strings.TrimSpace(line)
+		name := toks[1]
 
-		if len(clean) == 0 {
+		var err error
+		switch ok, _ := isReg(name.text); {
+		case name.kind != tokenWord || !isIdent(name.text):
+			err = errorAt(name, "constant name must be a valid identifier ([A-Za-z_][A-Za-z0-9_]*)")
+		case ok:
+			err = errorAt(name, "%s is a register and cannot name a constant", name.text)
+		case constDefs[name.text] != nil:
+			err = errorAt(name, "constant %s already defined on line %d", name.text, constDefs[name.text].line)
+		}
+
+		if err != nil {
+			fail(s, err)
 			continue
 		}
 
-		if clean[0] == ';' {
+		v, err := evalExpr(s.text, spanning(s.text, toks[2:]), consts)
+		if err != nil {
+			fail(s, err)
 			continue
 		}
 
-		if clean[0] == ':' {
-			label := strings.TrimSpace(clean[1:])
-			insts = append(insts, instructions.InstLabel{Label: uint16(jumps[label])})
+		consts[name.text] = v
+		constDefs[name.text] = s
+	}
+
+	labelDefs := map[labelKey]*sourceLine{}
+
+	// A local label belongs to the last global label before it in the same
+	// file. Labels made by macro expansions never start a scope.
+	globals := map[string]string{}
+
+	for _, s := range src {
+		toks := s.toks
+		file := s.position().File
+
+		if len(toks) == 0 || toks[0].kind != tokenColon {
+			s.global = globals[file]
 			continue
 		}
 
-		parts := cleanArray(strings.Split(clean, " "))
+		label, signature, err := parseLabel(s.text, toks)
 
-		switch parts[0] {
-		case "hlt":
-			insts = append(insts, instructions.InstHlt{})
-		case "dbg":
-			insts = append(insts, instructions.InstDbg{})
-		case "mov":
-			// Handle movlit and movreg
-			if len(parts) != 3 {
-				return nil, err("mov must have two arguments")
-			}
+		key := labelKey{namespace: in.namespaces[file], global: label.text}
+		if local, ok := strings.CutPrefix(label.text, "."); ok {
+			key.global, key.local = globals[file], local
+		} else if err == nil && s.expansion == nil {
+			globals[file] = label.text
+		}
+		s.global = globals[file]
+
+		switch {
+		case err != nil:
+		case !isIdent(s.unlocal(strings.TrimPrefix(label.text, "."))):
+			err = errorAt(label, "label must be a valid identifier ([A-Za-z_][A-Za-z0-9_]*)")
+		case key.global == "":
+			err = errorAt(label, "local label %s must follow the label it belongs to", label.text)
+		case labelDefs[key] != nil:
+			err = errorAt(label, "label %s already defined on line %d", key, labelDefs[key].line)
+		case len(unit.Labels) > math.MaxUint16:
+			err = errorAt(label, "too many labels (at most %d)", math.MaxUint16+1)
+		}
 
-			r1ok, r1 := isReg(parts[1])
-			if !r1ok {
-				return nil, err("mov first argument must be a register")
-			}
+		if err != nil {
+			fail(s, err)
+			continue
+		}
 
-			r2ok, r2 := isReg(parts[2])
-			if r2ok {
-				insts = append(insts, instructions.InstMovRegister{Register: uint8(r1), Source: uint8(r2)})
-				break
-			}
+		labelDefs[key] = s
+		labelLines[s] = len(unit.Labels)
 
-			v, e := strconv.ParseInt(parts[2], 10, 64)
-			if e != nil {
-				return nil, err("mov second argument must be a register or a number")
-			}
+		jumps[key] = len(unit.Labels)
+		unit.Labels = append(unit.Labels, key.String())
+		unit.Signatures = append(unit.Signatures, signature)
+	}
 
-			insts = append(insts, instructions.InstMovLiteral{Register: uint8(r1), Value: v})
-		case "ld":
-			if len(parts) != 2 {
-				return nil, err("ld must have one argument")
-			}
+	// Aliases apply from the line declaring them on, so they are handled
+	// along with the instructions.
+	aliases := map[string]int{}
+	aliasDefs := map[string]*sourceLine{}
+	writtenAs := map[int]string{} // instruction -> the alias it writes through
 
-			r1ok, r1 := isReg(parts[1])
-			if !r1ok {
-				return nil, err("ld argument must be a register")
-			}
+	for _, s := range src {
+		line, toks := s.text, s.toks
 
-			insts = append(insts, instructions.InstLd{Register: uint8(r1)})
-		case "st":
-			if len(parts) != 2 {
-				return nil, err("st must have one argument")
-			}
+		if len(toks) == 0 {
+			continue
+		}
 
-			r1ok, r1 := isReg(parts[1])
-			if !r1ok {
-				return nil, err("st argument must be a register")
+		switch {
+		case toks[0].kind == tokenWord && toks[0].text == ".reg":
+			alias, err := parseAlias(line, toks, aliases, config)
+			if err != nil {
+				fail(s, err)
+				continue
 			}
 
-			insts = append(insts, instructions.InstSt{Register: uint8(r1)})
-		case "push":
-			if len(parts) != 2 {
-				return nil, err("push must have one argument")
+			name := toks[1]
+			if previous, ok := aliasDefs[name.text]; ok {
+				warn(s, errorAt(name, "alias %s shadows the alias on line %d for r%d", name.text, previous.line, aliases[name.text]))
+			} else if _, ok := consts[name.text]; ok {
+				warn(s, errorAt(name, "alias %s shadows the constant of the same name", name.text))
 			}
 
-			vOk, v := isLiteral(parts[1])
-			if !vOk {
-				return nil, err("push argument must be a number")
-			}
+			aliases[name.text] = alias
+			aliasDefs[name.text] = s
 
-			insts = append(insts, instructions.InstPush{Value: v})
-		case "dup":
-			if len(parts) != 1 {
-				return nil, err("dup must have no arguments")
+			continue
+		case toks[0].kind == tokenWord && toks[0].text[0] == '.':
+			if toks[0].text != ".limits" && toks[0].text != ".const" {
+				fail(s, errorAt(toks[0], "unknown directive %s", toks[0].text))
 			}
 
-			insts = append(insts, instructions.InstDup{})
-		case "drop":
-			if len(parts) != 1 {
-				return nil, err("drop must have no arguments")
+			continue
+		case toks[0].kind == tokenColon:
+			if id, ok := labelLines[s]; ok {
+				emit(instructions.InstLabel{Label: uint32(id)}, s)
 			}
 
-			insts = append(insts, instructions.InstDrop{})
-		case "swap":
-			if len(parts) != 1 {
-				return nil, err("swap must have no arguments")
-			}
+			continue
+		}
 
-			insts = append(insts, instructions.InstSwap{})
-		case "add":
-			if len(parts) != 1 {
-				return nil, err("add must have no arguments")
+		if toks[0].text == "prints" {
+			insts, err := parsePrints(line, toks)
+			if err != nil {
+				fail(s, err)
 			}
 
-			insts = append(insts, instructions.InstAdd{})
-		case "sub":
-			if len(parts) != 1 {
-				return nil, err("sub must have no arguments")
+			for _, inst := range insts {
+				emit(inst, s)
 			}
 
-			insts = append(insts, instructions.InstSub{})
-		case "mul":
-			if len(parts) != 1 {
-				return nil, err("mul must have no arguments")
-			}
+			continue
+		}
 
-			insts = append(insts, instructions.InstMul{})
-		case "div":
-			if len(parts) != 1 {
-				return nil, err("div must have no arguments")
-			}
+		syms := symbols{labels: jumps, consts: consts, aliases: aliases, scope: in.namespaces[s.position().File], global: s.global}
 
-			insts = append(insts, instructions.InstDiv{})
-		case "mod":
-			if len(parts) != 1 {
-				return nil, err("mod must have no arguments")
-			}
+		inst, err := parseInstruction(line, toks, syms, config)
+		if err != nil {
+			fail(s, err)
+			continue
+		}
 
-			insts = append(insts, instructions.InstMod{})
-		case "call":
-			if len(parts) != 2 {
-				return nil, err("call must have one argument")
+		if dest := writtenRegister(inst); dest >= 0 {
+			for _, tok := range toks[1:] {
+				if reg, ok := aliases[tok.text]; ok && reg == dest {
+					writtenAs[len(unit.Instructions)] = tok.text
+					break
+				}
 			}
+		}
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("call argument must be a label")
-			}
+		emit(inst, s)
+	}
 
-			insts = append(insts, instructions.InstCall{Label: jLoc})
-		case "jmp":
-			if len(parts) != 2 {
-				return nil, err("jmp must have one argument")
-			}
+	if !diags.HasErrors() {
+		diags = append(diags, unreadRegisters(unit, writtenAs)...)
+	}
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("jmp argument must be a label")
-			}
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return in.order[a.File] < in.order[b.File]
+		}
 
-			insts = append(insts, instructions.InstJmp{Label: jLoc})
-		case "jmpz":
-			if len(parts) != 2 {
-				return nil, err("jmpz must have one argument")
-			}
+		return a.Line < b.Line
+	})
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("jmpz argument must be a label")
-			}
+	if diags.HasErrors() {
+		return nil, diags
+	}
 
-			insts = append(insts, instructions.InstJmpZ{Label: jLoc})
-		case "jmpnz":
-			if len(parts) != 2 {
-				return nil, err("jmpnz must have one argument")
-			}
+	unit.Warnings = diags
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("jmpnz argument must be a label")
-			}
+	return unit, nil
+}
 
-			insts = append(insts, instructions.InstJmpNZ{Label: jLoc})
-		case "jmpp":
-			if len(parts) != 2 {
-				return nil, err("jmpp must have one argument")
-			}
+// parseAlias reads a .reg directive, which names a register, or another
+// alias, for the lines after it.
+func parseAlias(line string, toks []token, aliases map[string]int, config parseConfig) (int, error) {
+	if len(toks) != 3 {
+		return 0, errorAt(spanning(line, toks), ".reg must have a name and a register")
+	}
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("jmpp argument must be a label")
-			}
+	name, target := toks[1], toks[2]
 
-			insts = append(insts, instructions.InstJmpP{Label: jLoc})
-		case "jmpn":
-			if len(parts) != 2 {
-				return nil, err("jmpn must have one argument")
-			}
+	if ok, _ := isReg(name.text); ok {
+		return 0, errorAt(name, "%s is already a register name", name.text)
+	}
 
-			jOk, jLoc := isJump(parts[1])
-			if !jOk {
-				return nil, err("jmpn argument must be a label")
-			}
+	if name.kind != tokenWord || !isIdent(name.text) {
+		return 0, errorAt(name, "register alias must be a valid identifier ([A-Za-z_][A-Za-z0-9_]*)")
+	}
 
-			insts = append(insts, instructions.InstJmpN{Label: jLoc})
-		case "putn":
-			if len(parts) != 1 {
-				return nil, err("putn must have no arguments")
-			}
+	ok, reg := isReg(target.text)
+	if alias, found := aliases[target.text]; found && !ok {
+		ok, reg = true, alias
+	}
 
-			insts = append(insts, instructions.InstPutN{})
-		case "putc":
-			if len(parts) != 1 {
-				return nil, err("putc must have no arguments")
-			}
+	if !ok {
+		return 0, errorAt(target, ".reg second argument must be a register")
+	}
 
-			insts = append(insts, instructions.InstPutC{})
-		case "ret":
-			if len(parts) != 1 {
-				return nil, err("ret must have no arguments")
-			}
+	if reg >= config.limits.Registers {
+		return 0, errorAt(target, "register r%d is out of range (%d registers configured)", reg, config.limits.Registers)
+	}
 
-			insts = append(insts, instructions.InstRet{})
-		default:
-			return nil, err("unknown instruction")
+	return reg, nil
+}
+
+// writtenRegister returns the register inst stores to, or -1.
+func writtenRegister(inst instructions.Instruction) int {
+	switch inst := inst.(type) {
+	case instructions.InstMovRegister:
+		return int(inst.Register)
+	case instructions.InstMovLiteral:
+		return int(inst.Register)
+	case instructions.InstSt:
+		return int(inst.Register)
+	}
+
+	return -1
+}
+
+// unreadRegisters warns about registers that are stored to but never loaded
+// or copied from, pointing at the first store and naming the register as it
+// was written there.
+func unreadRegisters(u *Unit, writtenAs map[int]string) Diagnostics {
+	read := map[int]bool{}
+	written := map[int]int{}
+	order := []int{}
+
+	for i, inst := range u.Instructions {
+		switch inst := inst.(type) {
+		case instructions.InstLd:
+			read[int(inst.Register)] = true
+		case instructions.InstMovRegister:
+			read[int(inst.Source)] = true
+		}
+
+		dest := writtenRegister(inst)
+		if _, ok := written[dest]; dest >= 0 && !ok {
+			written[dest] = i
+			order = append(order, dest)
 		}
 	}
 
-	return insts, nil
+	diags := Diagnostics{}
+	for _, reg := range order {
+		if read[reg] {
+			continue
+		}
+
+		what := fmt.Sprintf("r%d", reg)
+		if name, ok := writtenAs[written[reg]]; ok {
+			what = fmt.Sprintf("%s (r%d)", name, reg)
+		}
+
+		diags = append(diags, u.diagnostic(SeverityWarning, written[reg], what+" is written but never read"))
+	}
+
+	return diags
 }
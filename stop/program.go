@@ -0,0 +1,105 @@
+package stop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type labelSpan struct {
+	offset int
+	name   string
+}
+
+type Program struct {
+	code    []byte
+	entry   int
+	version uint16
+	limits  Limits
+
+	ops   []op
+	fused []op
+	start int
+
+	debug  *DebugInfo
+	labels []labelSpan
+}
+
+func Load(data []byte) (*Program, error) {
+	c, err := ReadContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := loadCode(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Program{
+		code:    code.code,
+		entry:   code.entry,
+		version: c.Version,
+		debug:   code.debug,
+	}
+
+	if p.debug != nil {
+		for offset, name := range p.debug.Labels {
+			p.labels = append(p.labels, labelSpan{offset: int(offset), name: name})
+		}
+
+		sort.Slice(p.labels, func(i, j int) bool {
+			return p.labels[i].offset < p.labels[j].offset
+		})
+	}
+
+	p.limits = codeLimits(p.code)
+
+	if err := problems(p.verify(p.limits.Registers), p.Describe); err != nil {
+		return nil, err
+	}
+
+	ops, index := decodeOps(p.code)
+	p.ops = ops
+	p.start = index[p.entry]
+	p.fused = fuse(p.code, p.ops, p.start)
+
+	return p, nil
+}
+
+func (p *Program) Limits() Limits {
+	return p.limits
+}
+
+func (p *Program) Entry() int {
+	return p.entry
+}
+
+func (p *Program) Version() uint16 {
+	return p.version
+}
+
+func (p *Program) Debug() *DebugInfo {
+	return p.debug
+}
+
+func (p *Program) Describe(pc int) string {
+	if p.debug == nil {
+		return ""
+	}
+
+	where := ""
+	if pos, ok := p.debug.Position(pc); ok {
+		where = pos.String()
+	}
+
+	i := sort.Search(len(p.labels), func(i int) bool {
+		return p.labels[i].offset > pc
+	})
+
+	if i > 0 {
+		where = strings.TrimSpace(fmt.Sprintf("%s in %s", where, p.labels[i-1].name))
+	}
+
+	return where
+}
@@ -0,0 +1,86 @@
+package stop
+
+import "strings"
+
+// sourceLine is a line of source as the parser sees it, after macros are
+// expanded. Lines produced by an expansion keep the file and line of the
+// macro body they came from and record the invocation in expansion.
+type sourceLine struct {
+	file      string
+	line      int
+	text      string
+	toks      []token
+	expansion *expansion
+
+	global string // the label local labels on this line belong to
+}
+
+// expansion is one use of a macro. at is the invoking line, which may itself
+// come from another expansion.
+type expansion struct {
+	name string
+	id   int
+	at   *sourceLine
+}
+
+// position is where instructions from the line are attributed: the line
+// itself, or the outermost invocation for lines from a macro.
+func (s *sourceLine) position() Position {
+	for s.expansion != nil {
+		s = s.expansion.at
+	}
+
+	column := 1
+	if len(s.toks) > 0 {
+		column = s.toks[0].col + 1
+	}
+
+	return Position{File: s.file, Line: s.line, Column: column}
+}
+
+// unlocal strips the suffix that makes a label defined in a macro unique to
+// one expansion.
+func (s *sourceLine) unlocal(label string) string {
+	if s.expansion == nil {
+		return label
+	}
+
+	name, _, _ := strings.Cut(label, "@")
+	return name
+}
+
+func (s *sourceLine) diagnostic(err error) Diagnostic {
+	tok := token{text: strings.TrimSpace(s.text)}
+	tok.col = strings.Index(s.text, tok.text)
+
+	msg := err.Error()
+	if te, ok := err.(*tokenError); ok {
+		tok, msg = te.tok, te.msg
+	}
+
+	d := Diagnostic{
+		Severity: SeverityError,
+		File:     s.file,
+		Line:     s.line,
+		Column:   tok.col + 1,
+		Span:     len(tok.text),
+		Message:  msg,
+		Source:   s.text,
+	}
+
+	for e := s.expansion; e != nil; e = e.at.expansion {
+		name := e.at.toks[0]
+
+		d.Notes = append(d.Notes, Diagnostic{
+			Severity: SeverityNote,
+			File:     e.at.file,
+			Line:     e.at.line,
+			Column:   name.col + 1,
+			Span:     len(name.text),
+			Message:  "in expansion of macro " + e.name,
+			Source:   e.at.text,
+		})
+	}
+
+	return d
+}
@@ -0,0 +1,74 @@
+package stop
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Signature is a label's declared stack effect, written after the label as
+// ( inputs -- outputs ).
+type Signature struct {
+	Inputs  []string
+	Outputs []string
+}
+
+func (s *Signature) String() string {
+	parts := append([]string{"("}, s.Inputs...)
+	parts = append(parts, "--")
+	parts = append(parts, s.Outputs...)
+
+	return strings.Join(append(parts, ")"), " ")
+}
+
+type Unit struct {
+	File         string
+	Instructions []instructions.Instruction
+	Positions    []Position
+	Labels       []string
+	Signatures   []*Signature // by label id, nil where none was declared
+	Warnings     Diagnostics
+
+	source map[string][]string // lines of each file, for diagnostics
+}
+
+// diagnostic describes a problem with the instruction at index i, pointing at
+// its first word when the source is known.
+func (u *Unit) diagnostic(severity Severity, i int, msg string) Diagnostic {
+	d := Diagnostic{Severity: severity, Message: msg}
+
+	if i >= len(u.Positions) {
+		d.Message = fmt.Sprintf("instruction %d: %s", i, msg)
+		return d
+	}
+
+	pos := u.Positions[i]
+	d.File, d.Line, d.Column = pos.File, pos.Line, pos.Column
+
+	if lines := u.source[pos.File]; pos.Line-1 < len(lines) {
+		d.Source = lines[pos.Line-1]
+
+		rest := d.Source[min(pos.Column-1, len(d.Source)):]
+		if end := strings.IndexAny(rest, " \t"); end >= 0 {
+			rest = rest[:end]
+		}
+		d.Span = len(rest)
+	}
+
+	return d
+}
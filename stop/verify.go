@@ -0,0 +1,213 @@
+package stop
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+type Problem struct {
+	Offset  int
+	Message string
+	Source  string
+}
+
+func (p Problem) String() string {
+	prefix := ""
+	if p.Source != "" {
+		prefix = p.Source + ": "
+	}
+
+	return fmt.Sprintf("%s%s at %x", prefix, p.Message, p.Offset)
+}
+
+type VerifyError struct {
+	Problems []Problem
+}
+
+func (e *VerifyError) Error() string {
+	lines := []string{}
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func problems(found []Problem, describe func(int) string) error {
+	if len(found) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Offset < found[j].Offset
+	})
+
+	for i := range found {
+		found[i].Source = describe(found[i].Offset)
+	}
+
+	return &VerifyError{Problems: found}
+}
+
+// Verify checks bytecode without running it. Problems with the container
+// itself are returned as a plain error; problems with the code are returned
+// together as a *VerifyError.
+func Verify(data []byte) error {
+	_, err := Load(data)
+	return err
+}
+
+// walk decodes code, calling visit for every instruction. An unknown opcode
+// is reported and skipped a byte at a time; a truncated instruction ends the
+// walk.
+func walk(code []byte, visit func(offset int, inst instructions.Instruction, spec *instructions.Spec)) []Problem {
+	found := []Problem{}
+
+	for offset := 0; offset < len(code); {
+		inst, size, err := instructions.DecodeAt(code, offset)
+		if err != nil {
+			spec, ok := instructions.Lookup(code[offset])
+			if !ok {
+				found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("unknown opcode %02x", code[offset])})
+				offset++
+				continue
+			}
+
+			found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("truncated %s: needs %d bytes, %d left", spec.Mnemonic, spec.Size, len(code)-offset)})
+			break
+		}
+
+		spec, _ := instructions.Lookup(inst.Opcode())
+		visit(offset, inst, spec)
+		offset += size
+	}
+
+	return found
+}
+
+// verifySymbolic checks legacy code, whose jumps still refer to label ids.
+func verifySymbolic(code []byte) []Problem {
+	type ref struct {
+		offset   int
+		mnemonic string
+		label    int64
+	}
+
+	defined := map[int64]bool{}
+	refs := []ref{}
+	found := []Problem{}
+	registers := codeLimits(code).Registers
+
+	found = append(found, walk(code, func(offset int, inst instructions.Instruction, spec *instructions.Spec) {
+		if label, ok := inst.(instructions.InstLabel); ok {
+			if defined[int64(label.Label)] {
+				found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("label %d defined more than once", label.Label)})
+			}
+
+			defined[int64(label.Label)] = true
+			return
+		}
+
+		found = append(found, checkOperands(offset, inst, spec, registers)...)
+
+		for i, kind := range spec.Operands {
+			if kind == instructions.OperandLabel {
+				refs = append(refs, ref{offset: offset, mnemonic: spec.Mnemonic, label: inst.Operands()[i]})
+			}
+		}
+	})...)
+
+	for _, r := range refs {
+		if !defined[r.label] {
+			found = append(found, Problem{Offset: r.offset, Message: fmt.Sprintf("%s refers to undefined label %d", r.mnemonic, r.label)})
+		}
+	}
+
+	return found
+}
+
+// codeLimits returns the limits set by a leading limits instruction.
+func codeLimits(code []byte) Limits {
+	if inst, _, err := instructions.DecodeAt(code, 0); err == nil {
+		if limits, ok := inst.(instructions.InstLimits); ok {
+			return Limits{
+				Registers:     int(limits.Registers),
+				StackSize:     int(limits.Stack),
+				CallStackSize: int(limits.CallStack),
+			}
+		}
+	}
+
+	return DefaultLimits
+}
+
+// checkOperands reports problems an instruction has on its own, whether or not
+// its code has been linked.
+func checkOperands(offset int, inst instructions.Instruction, spec *instructions.Spec, registers int) []Problem {
+	found := []Problem{}
+
+	problem := func(format string, args ...any) {
+		found = append(found, Problem{Offset: offset, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if limits, ok := inst.(instructions.InstLimits); ok {
+		if offset != 0 {
+			problem("limits must be the first instruction")
+		}
+
+		l := Limits{Registers: int(limits.Registers), StackSize: int(limits.Stack), CallStackSize: int(limits.CallStack)}
+		if err := l.Validate(); err != nil {
+			problem("%s", err)
+		}
+	}
+
+	for i, kind := range spec.Operands {
+		if arg := inst.Operands()[i]; kind == instructions.OperandRegister && int(arg) >= registers {
+			problem("%s uses register r%d but only %d registers are available", spec.Mnemonic, arg, registers)
+		}
+	}
+
+	return found
+}
+
+// verify checks linked code. Registers are checked against the given count,
+// which is the program's own limit at load time and the VM's when it runs.
+func (p *Program) verify(registers int) []Problem {
+	boundaries := map[int]bool{len(p.code): true}
+	targets := map[int]int{}
+	found := []Problem{}
+
+	found = append(found, walk(p.code, func(offset int, inst instructions.Instruction, spec *instructions.Spec) {
+		boundaries[offset] = true
+
+		if spec.Symbolic {
+			found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("unresolved %s in linked code", spec.Mnemonic)})
+			return
+		}
+
+		found = append(found, checkOperands(offset, inst, spec, registers)...)
+
+		for i, kind := range spec.Operands {
+			if kind == instructions.OperandAddress {
+				targets[offset] = int(inst.Operands()[i])
+			}
+		}
+	})...)
+
+	for offset, target := range targets {
+		if target > len(p.code) {
+			found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("target %x is outside the code", target)})
+		} else if !boundaries[target] {
+			found = append(found, Problem{Offset: offset, Message: fmt.Sprintf("target %x is not an instruction boundary", target)})
+		}
+	}
+
+	if !boundaries[p.entry] {
+		found = append(found, Problem{Offset: p.entry, Message: "entry point is not an instruction boundary"})
+	}
+
+	return found
+}
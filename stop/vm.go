@@ -1,7 +1,11 @@
 package stop
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/vcokltfre/stop/stop/instructions"
 )
@@ -9,411 +13,608 @@ import (
 const (
 	STACK_SIZE      = 1024
 	CALL_STACK_SIZE = 64
+	REGISTER_COUNT  = 16
+	CHECK_INTERVAL  = 1024
 	DEBUG           = false
 )
 
+type Option func(*VM)
+
+func WithOutput(w io.Writer) Option {
+	return func(v *VM) {
+		v.out = bufio.NewWriter(w)
+	}
+}
+
+func WithInput(r io.Reader) Option {
+	return func(v *VM) {
+		v.in = bufio.NewReader(r)
+	}
+}
+
+func WithFuel(fuel int64) Option {
+	return func(v *VM) {
+		v.SetFuel(fuel)
+	}
+}
+
+func WithStackSize(size int) Option {
+	return func(v *VM) {
+		v.limits.StackSize = size
+	}
+}
+
+func WithCallStackSize(size int) Option {
+	return func(v *VM) {
+		v.limits.CallStackSize = size
+	}
+}
+
+func WithRegisters(count int) Option {
+	return func(v *VM) {
+		v.limits.Registers = count
+	}
+}
+
+// WithMaxLimits refuses to run programs whose limits, after any other
+// options, ask for more than max.
+func WithMaxLimits(max Limits) Option {
+	return func(v *VM) {
+		v.max = &max
+	}
+}
+
+func WithProfile(p *Profile) Option {
+	return func(v *VM) {
+		v.profile = p
+	}
+}
+
 type VM struct {
 	stack        []int64
 	stackTop     int
 	callStack    []int
 	callStackTop int
-	program      []byte
 	registers    []int64
 
-	jumps map[uint16]int
-	index int
+	limits  Limits
+	max     *Limits
+	program *Program
+	code    []byte
+	ops     []op
+	fused   []op
+	profile *Profile
+
+	out *bufio.Writer
+	in  *bufio.Reader
+
+	index  int // next op
+	inst   int // op being executed
+	halted bool
+
+	fuel    int64
+	metered bool
+
+	invalid error
+}
+
+func NewVM(program *Program, opts ...Option) *VM {
+	v := &VM{
+		limits:  program.limits,
+		program: program,
+		code:    program.code,
+		ops:     program.ops,
+		fused:   program.fused,
+		out:     bufio.NewWriter(os.Stdout),
+		in:      bufio.NewReader(os.Stdin),
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	err := v.limits.Validate()
+	if err == nil && v.max != nil {
+		err = v.limits.within(*v.max)
+	}
+
+	if err != nil {
+		v.invalid = err
+		v.limits = Limits{}
+	}
+
+	v.stack = make([]int64, max(v.limits.StackSize, 0))
+	v.callStack = make([]int, max(v.limits.CallStackSize, 0))
+	v.registers = make([]int64, max(v.limits.Registers, 0))
+
+	if v.invalid == nil && v.limits.Registers < program.limits.Registers {
+		v.invalid = problems(program.verify(v.limits.Registers), program.Describe)
+	}
+
+	v.Reset()
+
+	return v
 }
 
 func (v *VM) debug(data ...any) {
 	if DEBUG {
-		fmt.Println(append([]any{"[DEBUG]", fmt.Sprintf("%x", v.index)}, data...)...)
-	}
-}
-
-func (v *VM) buildJumps() {
-	index := 0
-
-	for index < len(v.program) {
-		curr := v.program[index]
-		switch curr {
-		case instructions.IHeaderHlt:
-			index += instructions.ISizeHlt
-		case instructions.IHeaderDbg:
-			index += instructions.ISizeDbg
-		case instructions.IHeaderMovLiteral:
-			index += instructions.ISizeMovLiteral
-		case instructions.IHeaderMovRegister:
-			index += instructions.ISizeMovRegister
-		case instructions.IHeaderPush:
-			index += instructions.ISizePush
-		case instructions.IHeaderDup:
-			index += instructions.ISizeDup
-		case instructions.IHeaderDrop:
-			index += instructions.ISizeDrop
-		case instructions.IHeaderSwap:
-			index += instructions.ISizeSwap
-		case instructions.IHeaderLd:
-			index += instructions.ISizeLd
-		case instructions.IHeaderSt:
-			index += instructions.ISizeSt
-		case instructions.IHeaderAdd:
-			index += instructions.ISizeAdd
-		case instructions.IHeaderSub:
-			index += instructions.ISizeSub
-		case instructions.IHeaderMul:
-			index += instructions.ISizeMul
-		case instructions.IHeaderDiv:
-			index += instructions.ISizeDiv
-		case instructions.IHeaderMod:
-			index += instructions.ISizeMod
-		case instructions.IHeaderLabel:
-			index += instructions.ISizeLabel
-			v.jumps[uint16(v.program[index-1])<<8|uint16(v.program[index-2])] = index
-		case instructions.IHeaderCall:
-			index += instructions.ISizeCall
-		case instructions.IHeaderJmp:
-			index += instructions.ISizeJmp
-		case instructions.IHeaderJmpZ:
-			index += instructions.ISizeJmp
-		case instructions.IHeaderJmpNZ:
-			index += instructions.ISizeJmp
-		case instructions.IHeaderJmpP:
-			index += instructions.ISizeJmp
-		case instructions.IHeaderJmpN:
-			index += instructions.ISizeJmp
-		case instructions.IHeaderRet:
-			index += instructions.ISizeRet
-		case instructions.IHeaderPutN:
-			index += instructions.ISizePutN
-		case instructions.IHeaderPutC:
-			index += instructions.ISizePutC
-		default:
-			panic("invalid instruction: " + fmt.Sprintf("%x", curr))
-		}
+		fmt.Println(append([]any{"[DEBUG]", fmt.Sprintf("%x", v.ops[v.index].pc)}, data...)...)
 	}
 }
 
-func (v *VM) stackPush(val int64) {
-	if v.stackTop >= STACK_SIZE-1 {
-		panic("stack overflow")
+func (v *VM) fault(kind ErrorKind) *VMError {
+	backtrace := []int{}
+	frames := []string{}
+	for i := v.callStackTop; i >= 0; i-- {
+		addr := v.ops[v.callStack[i]-1].pc
+		backtrace = append(backtrace, addr)
+		frames = append(frames, v.program.Describe(addr))
 	}
 
-	v.debug("pushing", v.stackTop, val)
+	pc := v.ops[v.inst].pc
+
+	var opcode uint8
+	if pc < len(v.code) {
+		opcode = v.code[pc]
+	}
+
+	return &VMError{
+		Kind:       kind,
+		PC:         pc,
+		Opcode:     opcode,
+		StackDepth: v.stackTop + 1,
+		Backtrace:  backtrace,
+		Source:     v.program.Describe(pc),
+		Frames:     frames,
+	}
+}
+
+func (v *VM) stackPush(val int64) error {
+	if v.stackTop >= len(v.stack)-1 {
+		return v.fault(KindStackOverflow)
+	}
 
 	v.stackTop++
 	v.stack[v.stackTop] = val
+
+	return nil
 }
 
-func (v *VM) stackPop() int64 {
+func (v *VM) stackPop() (int64, error) {
 	if v.stackTop < 0 {
-		panic("stack underflow")
+		return 0, v.fault(KindStackUnderflow)
 	}
 
-	v.debug("popping", v.stackTop, v.stack[v.stackTop])
-
 	v.stackTop--
-	return v.stack[v.stackTop+1]
+	return v.stack[v.stackTop+1], nil
 }
 
-func (v *VM) callStackPush(val int) {
-	if v.callStackTop >= CALL_STACK_SIZE-1 {
-		panic("call stack overflow")
+func (v *VM) callStackPush(val int) error {
+	if v.callStackTop >= len(v.callStack)-1 {
+		return v.fault(KindCallStackOverflow)
 	}
 
 	v.callStackTop++
 	v.callStack[v.callStackTop] = val
+
+	return nil
 }
 
-func (v *VM) callStackPop() int {
+func (v *VM) callStackPop() (int, error) {
 	if v.callStackTop < 0 {
-		panic("call stack underflow")
+		return 0, v.fault(KindCallStackUnderflow)
 	}
 
 	v.callStackTop--
-	return v.callStack[v.callStackTop+1]
+	return v.callStack[v.callStackTop+1], nil
+}
+
+var handlers [256]func(*VM, *op) error
+
+func init() {
+	impls := map[uint8]func(*VM, *op) error{
+		instructions.IHeaderHlt:         (*VM).instHlt,
+		instructions.IHeaderDbg:         (*VM).instNop,
+		instructions.IHeaderLimits:      (*VM).instNop,
+		instructions.IHeaderMovLiteral:  (*VM).instMovLiteral,
+		instructions.IHeaderMovRegister: (*VM).instMovRegister,
+		instructions.IHeaderPush:        (*VM).instPush,
+		instructions.IHeaderDup:         (*VM).instDup,
+		instructions.IHeaderDrop:        (*VM).instDrop,
+		instructions.IHeaderSwap:        (*VM).instSwap,
+		instructions.IHeaderLd:          (*VM).instLd,
+		instructions.IHeaderSt:          (*VM).instSt,
+		instructions.IHeaderAdd:         (*VM).instAdd,
+		instructions.IHeaderSub:         (*VM).instSub,
+		instructions.IHeaderMul:         (*VM).instMul,
+		instructions.IHeaderDiv:         (*VM).instDiv,
+		instructions.IHeaderMod:         (*VM).instMod,
+		instructions.IHeaderCallAddr:    (*VM).instCall,
+		instructions.IHeaderJmpAddr:     (*VM).instJmp,
+		instructions.IHeaderJmpZAddr:    (*VM).instJmpZ,
+		instructions.IHeaderJmpNZAddr:   (*VM).instJmpNZ,
+		instructions.IHeaderJmpPAddr:    (*VM).instJmpP,
+		instructions.IHeaderJmpNAddr:    (*VM).instJmpN,
+		instructions.IHeaderRet:         (*VM).instRet,
+		instructions.IHeaderPutN:        (*VM).instPutN,
+		instructions.IHeaderPutC:        (*VM).instPutC,
+	}
+
+	for _, spec := range instructions.Table {
+		if spec.Symbolic {
+			continue
+		}
+
+		impl, ok := impls[spec.Opcode]
+		if !ok {
+			panic(fmt.Sprintf("no VM implementation for instruction %s", spec.Name))
+		}
+
+		handlers[spec.Opcode] = impl
+	}
+}
+
+func (v *VM) instNop(*op) error {
+	return nil
+}
+
+func (v *VM) instHlt(*op) error {
+	v.index = v.inst
+	v.halted = true
+	return nil
+}
+
+func (v *VM) instEnd(*op) error {
+	return v.instHlt(nil)
+}
+
+func (v *VM) instMovLiteral(o *op) error {
+	v.registers[o.a] = o.val
+	return nil
 }
 
-func (v *VM) getReg() uint8 {
-	return v.program[v.index]
+func (v *VM) instMovRegister(o *op) error {
+	v.registers[o.a] = v.registers[o.b]
+	return nil
 }
 
-func (v *VM) getU16() uint16 {
-	return uint16(v.program[v.index+1])<<8 | uint16(v.program[v.index])
+func (v *VM) instPush(o *op) error {
+	return v.stackPush(o.val)
 }
 
-func (v *VM) getI64() int64 {
-	var i int64
-	for j := 0; j < 8; j++ {
-		i |= int64(v.program[v.index+j]) << uint64(j*8)
+func (v *VM) instDup(*op) error {
+	if v.stackTop < 0 {
+		return v.fault(KindStackUnderflow)
+	}
+	return v.stackPush(v.stack[v.stackTop])
+}
+
+func (v *VM) instDrop(*op) error {
+	_, err := v.stackPop()
+	return err
+}
+
+func (v *VM) instSwap(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	s := v.stack
+	s[v.stackTop], s[v.stackTop-1] = s[v.stackTop-1], s[v.stackTop]
+	return nil
+}
+
+func (v *VM) instLd(o *op) error {
+	return v.stackPush(v.registers[o.a])
+}
+
+func (v *VM) instSt(o *op) error {
+	val, err := v.stackPop()
+	if err != nil {
+		return err
 	}
-	return i
+	v.registers[o.a] = val
+	return nil
 }
 
-func (v *VM) instDebug() {
-	fmt.Println("TODO: implement debug instruction")
+func (v *VM) instAdd(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	v.stack[v.stackTop] = v.stack[v.stackTop+1] + v.stack[v.stackTop]
+	return nil
 }
 
-func (v *VM) instMovLiteral() {
-	v.index += 1
-	reg := v.getReg()
-	v.index += 1
-	val := v.getI64()
-	v.registers[int(reg)] = val
-	v.index += 8
+func (v *VM) instSub(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	v.stack[v.stackTop] = v.stack[v.stackTop+1] - v.stack[v.stackTop]
+	return nil
 }
 
-func (v *VM) instMovRegister() {
-	v.index += 1
-	reg := v.getReg()
-	v.index += 1
-	src := v.getReg()
-	v.registers[int(reg)] = v.registers[int(src)]
-	v.index += 1
+func (v *VM) instMul(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	v.stack[v.stackTop] = v.stack[v.stackTop+1] * v.stack[v.stackTop]
+	return nil
+}
+
+func (v *VM) instDiv(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	if v.stack[v.stackTop-1] == 0 {
+		return v.fault(KindDivisionByZero)
+	}
+	v.stackTop--
+	v.stack[v.stackTop] = v.stack[v.stackTop+1] / v.stack[v.stackTop]
+	return nil
 }
 
-func (v *VM) instPush() {
+func (v *VM) instMod(*op) error {
+	if v.stackTop < 1 {
+		return v.fault(KindStackUnderflow)
+	}
+	if v.stack[v.stackTop-1] == 0 {
+		return v.fault(KindDivisionByZero)
+	}
+	v.stackTop--
+	v.stack[v.stackTop] = v.stack[v.stackTop+1] % v.stack[v.stackTop]
+	return nil
+}
+
+func (v *VM) instCall(o *op) error {
+	if err := v.callStackPush(v.index); err != nil {
+		return err
+	}
+	v.index = o.target
+	return nil
+}
+
+func (v *VM) instJmp(o *op) error {
+	v.index = o.target
+	return nil
+}
+
+func (v *VM) instJmpZ(o *op) error {
+	if v.stackTop < 0 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	if v.stack[v.stackTop+1] == 0 {
+		v.index = o.target
+	}
+	return nil
+}
+
+func (v *VM) instJmpNZ(o *op) error {
+	if v.stackTop < 0 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	if v.stack[v.stackTop+1] != 0 {
+		v.index = o.target
+	}
+	return nil
+}
+
+func (v *VM) instJmpP(o *op) error {
+	if v.stackTop < 0 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	if v.stack[v.stackTop+1] > 0 {
+		v.index = o.target
+	}
+	return nil
+}
+
+func (v *VM) instJmpN(o *op) error {
+	if v.stackTop < 0 {
+		return v.fault(KindStackUnderflow)
+	}
+	v.stackTop--
+	if v.stack[v.stackTop+1] < 0 {
+		v.index = o.target
+	}
+	return nil
+}
+
+func (v *VM) instRet(*op) error {
+	addr, err := v.callStackPop()
+	if err != nil {
+		return err
+	}
+	v.index = addr
+	return nil
+}
+
+func (v *VM) instPutN(*op) error {
+	val, err := v.stackPop()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(v.out, val); err != nil {
+		return v.fault(KindIO)
+	}
+	return nil
+}
+
+func (v *VM) instPutC(*op) error {
+	val, err := v.stackPop()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(v.out, "%c", val); err != nil {
+		return v.fault(KindIO)
+	}
+	return nil
+}
+
+func (v *VM) step(ops []op) error {
+	v.inst = v.index
+	o := &ops[v.index]
 	v.index++
-	v.stackPush(v.getI64())
-	v.index += 8
+
+	if DEBUG {
+		v.debug(o.pc)
+	}
+
+	err := o.exec(v, o)
+
+	if v.profile != nil && o.pc < len(v.code) {
+		v.profile.observe(v.code[o.pc], v.index != v.inst+1)
+	}
+
+	return err
 }
 
-func (v *VM) instDup() {
-	val := v.stackPop()
-	v.stackPush(val)
-	v.stackPush(val)
-	v.index += instructions.ISizeDup
+func (v *VM) Reset() {
+	clear(v.stack)
+	clear(v.callStack)
+	clear(v.registers)
+
+	v.stackTop = -1
+	v.callStackTop = -1
+	v.index = v.program.start
+	v.inst = v.program.start
+	v.halted = false
 }
 
-func (v *VM) instDrop() {
-	v.stackPop()
-	v.index += instructions.ISizeDrop
+func (v *VM) Registers() []int64 {
+	return append([]int64{}, v.registers...)
 }
 
-func (v *VM) instSwap() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a)
-	v.stackPush(b)
-	v.index += instructions.ISizeSwap
+func (v *VM) Stack() []int64 {
+	return append([]int64{}, v.stack[:v.stackTop+1]...)
 }
 
-func (v *VM) instLd() {
-	v.index += 1
-	reg := v.getReg()
-	v.stackPush(v.registers[int(reg)])
-	v.index += 1
+func (v *VM) PC() int {
+	return v.ops[v.index].pc
 }
 
-func (v *VM) instSt() {
-	v.index += 1
-	reg := v.getReg()
-	v.registers[int(reg)] = v.stackPop()
-	v.index += 1
+func (v *VM) Limits() Limits {
+	return v.limits
 }
 
-func (v *VM) instAdd() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a + b)
-	v.index += instructions.ISizeAdd
+func (v *VM) Halted() bool {
+	return v.halted
 }
 
-func (v *VM) instSub() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a - b)
-	v.index += instructions.ISizeSub
+func (v *VM) Fuel() int64 {
+	return v.fuel
 }
 
-func (v *VM) instMul() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a * b)
-	v.index += instructions.ISizeMul
+func (v *VM) SetFuel(fuel int64) {
+	v.fuel = fuel
+	v.metered = true
 }
 
-func (v *VM) instDiv() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a / b)
-	v.index += instructions.ISizeDiv
+func (v *VM) burn() error {
+	if !v.metered || v.index == len(v.ops)-1 {
+		return nil
+	}
+
+	if v.fuel <= 0 {
+		v.inst = v.index
+		return v.fault(KindOutOfFuel)
+	}
+
+	v.fuel--
+
+	return nil
 }
 
-func (v *VM) instMod() {
-	a := v.stackPop()
-	b := v.stackPop()
-	v.stackPush(a % b)
-	v.index += instructions.ISizeMod
+func (v *VM) cancelled(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+
+	v.inst = v.index
+	fault := v.fault(KindCancelled)
+	fault.Err = err
+
+	return fault
 }
 
-func (v *VM) instJmp() {
-	v.index += 1
-	addr := v.getU16()
-	v.index = v.jumps[addr]
+func (v *VM) flush(err error) error {
+	if flushErr := v.out.Flush(); err == nil && flushErr != nil {
+		return v.fault(KindIO)
+	}
+
+	return err
 }
 
-func (v *VM) instJmpZ() {
-	v.index += 1
-	addr := v.getU16()
-	val := v.stackPop()
-	if val == 0 {
-		v.index = v.jumps[addr]
-	} else {
-		v.index += 2
-	}
-}
-
-func (v *VM) instJmpNZ() {
-	v.index += 1
-	addr := v.getU16()
-	val := v.stackPop()
-	if val != 0 {
-		v.index = v.jumps[addr]
-	} else {
-		v.index += 2
-	}
-}
-
-func (v *VM) instJmpP() {
-	v.index += 1
-	addr := v.getU16()
-	val := v.stackPop()
-	if val > 0 {
-		v.index = v.jumps[addr]
-	} else {
-		v.index += 2
-	}
-}
-
-func (v *VM) instJmpN() {
-	v.index += 1
-	addr := v.getU16()
-	val := v.stackPop()
-	if val < 0 {
-		v.index = v.jumps[addr]
-	} else {
-		v.index += 2
-	}
-}
-
-func (v *VM) instRet() {
-	v.index = v.callStackPop()
-}
-
-func (v *VM) instPutN() {
-	v.index += 1
-	val := v.stackPop()
-	fmt.Println(val)
-}
-
-func (v *VM) instPutC() {
-	v.index += 1
-	val := v.stackPop()
-	fmt.Printf("%c", val)
-}
-
-func (v *VM) step() bool {
-	if v.index >= len(v.program) {
-		return true
-	}
-
-	curr := v.program[v.index]
-	switch curr {
-	case instructions.IHeaderHlt:
-		return true
-	case instructions.IHeaderDbg:
-		v.instDebug()
-		v.index += instructions.ISizeDbg
-	case instructions.IHeaderMovLiteral:
-		v.debug("mov literal")
-		v.instMovLiteral()
-	case instructions.IHeaderMovRegister:
-		v.debug("mov register")
-		v.instMovRegister()
-	case instructions.IHeaderPush:
-		v.debug("push")
-		v.instPush()
-	case instructions.IHeaderDup:
-		v.debug("dup")
-		v.instDup()
-	case instructions.IHeaderDrop:
-		v.debug("drop")
-		v.instDrop()
-	case instructions.IHeaderSwap:
-		v.debug("swap")
-		v.instSwap()
-	case instructions.IHeaderLd:
-		v.debug("ld")
-		v.instLd()
-	case instructions.IHeaderSt:
-		v.debug("st")
-		v.instSt()
-	case instructions.IHeaderAdd:
-		v.debug("add")
-		v.instAdd()
-	case instructions.IHeaderSub:
-		v.debug("sub")
-		v.instSub()
-	case instructions.IHeaderMul:
-		v.debug("mul")
-		v.instMul()
-	case instructions.IHeaderDiv:
-		v.debug("div")
-		v.instDiv()
-	case instructions.IHeaderMod:
-		v.debug("mod")
-		v.instMod()
-	case instructions.IHeaderLabel:
-		v.debug("label")
-		v.index += instructions.ISizeLabel
-	case instructions.IHeaderCall:
-		v.debug("call")
-		v.index += 1
-		v.callStackPush(v.index + 2)
-		v.index = v.jumps[v.getU16()]
-	case instructions.IHeaderJmp:
-		v.debug("jmp")
-		v.instJmp()
-	case instructions.IHeaderJmpZ:
-		v.debug("jmpz")
-		v.instJmpZ()
-	case instructions.IHeaderJmpNZ:
-		v.debug("jmpnz")
-		v.instJmpNZ()
-	case instructions.IHeaderJmpP:
-		v.debug("jmpp")
-		v.instJmpP()
-	case instructions.IHeaderJmpN:
-		v.debug("jmpn")
-		v.instJmpN()
-	case instructions.IHeaderRet:
-		v.debug("ret")
-		v.instRet()
-	case instructions.IHeaderPutN:
-		v.debug("putn")
-		v.instPutN()
-	case instructions.IHeaderPutC:
-		v.debug("putc")
-		v.instPutC()
-	default:
-		panic("invalid instruction: " + fmt.Sprintf("%x", curr) + " at " + fmt.Sprintf("%x (%d)", v.index, v.index))
-	}
-	return false
-}
-
-func (v *VM) Run(code []byte) {
-	v.stack = make([]int64, STACK_SIZE)
-	v.callStack = make([]int, CALL_STACK_SIZE)
-	v.program = code
-	v.jumps = make(map[uint16]int)
-	v.registers = make([]int64, 16)
-
-	v.buildJumps()
-
-	for {
-		stop := v.step()
-		if stop {
-			break
+func (v *VM) Step() (bool, error) {
+	if v.invalid != nil {
+		return false, v.invalid
+	}
+
+	if v.halted {
+		return true, nil
+	}
+
+	if err := v.burn(); err != nil {
+		return false, err
+	}
+
+	if err := v.step(v.ops); err != nil {
+		return false, v.flush(err)
+	}
+
+	return v.halted, v.flush(nil)
+}
+
+func (v *VM) Run(ctx context.Context) error {
+	if v.invalid != nil {
+		return v.invalid
+	}
+
+	if err := v.cancelled(ctx); err != nil {
+		return err
+	}
+
+	done := ctx.Done()
+
+	ops := v.fused
+	if v.metered || v.profile != nil {
+		ops = v.ops
+	}
+
+	if done == nil && !v.metered && v.profile == nil {
+		for !v.halted {
+			v.inst = v.index
+			o := &ops[v.index]
+			v.index++
+
+			if err := o.exec(v, o); err != nil {
+				return v.flush(err)
+			}
 		}
+
+		return v.flush(nil)
 	}
+
+	for n := 1; !v.halted; n++ {
+		if done != nil && n%CHECK_INTERVAL == 0 {
+			if err := v.cancelled(ctx); err != nil {
+				return v.flush(err)
+			}
+		}
+
+		if err := v.burn(); err != nil {
+			return v.flush(err)
+		}
+
+		if err := v.step(ops); err != nil {
+			return v.flush(err)
+		}
+	}
+
+	return v.flush(nil)
 }
@@ -0,0 +1,222 @@
+package stop
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/vcokltfre/stop/stop/instructions"
+)
+
+func load(t testing.TB, source string) *Program {
+	t.Helper()
+
+	unit, err := ParseUnit(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := CompileUnit(unit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := Load(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return program
+}
+
+func TestResumeOutOfFuel(t *testing.T) {
+	source, err := os.ReadFile("../examples/fib.stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program := load(t, string(source))
+
+	want := &bytes.Buffer{}
+	whole := NewVM(program, WithOutput(want))
+	if err := whole.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fuel := range []int64{1, 7, 100} {
+		got := &bytes.Buffer{}
+		v := NewVM(program, WithOutput(got), WithFuel(fuel))
+
+		runs := 0
+		for err := v.Run(context.Background()); err != nil; err = v.Run(context.Background()) {
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Kind != KindOutOfFuel || !vmErr.Resumable() {
+				t.Fatalf("fuel %d: got %v, want running out of fuel", fuel, err)
+			}
+
+			v.SetFuel(fuel)
+			runs++
+		}
+
+		if runs == 0 {
+			t.Errorf("fuel %d: never ran out", fuel)
+		}
+
+		if got.String() != want.String() || !slices.Equal(v.Stack(), whole.Stack()) || !slices.Equal(v.Registers(), whole.Registers()) {
+			t.Errorf("fuel %d: resumed run ended with output %q, stack %v and registers %v, want %q, %v and %v",
+				fuel, got, v.Stack(), v.Registers(), want, whole.Stack(), whole.Registers())
+		}
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	program := load(t, "push 1\nputn\nhlt\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := &bytes.Buffer{}
+	v := NewVM(program, WithOutput(out))
+
+	err := v.Run(ctx)
+
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Kind != KindCancelled || !vmErr.Resumable() || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want a resumable cancellation", err)
+	}
+
+	if vmErr.PC != 0 || out.Len() != 0 || v.Halted() {
+		t.Fatalf("cancelled run started: pc %x, output %q", vmErr.PC, out)
+	}
+
+	if err := v.Run(context.Background()); err != nil || out.String() != "1\n" {
+		t.Fatalf("resumed run gave %q, %v", out, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		kind      ErrorKind
+		pc        int
+		depth     int
+		backtrace []int
+	}{
+		{"division by zero", "push 0\npush 5\ndiv\n", KindDivisionByZero, 18, 2, []int{}},
+		{"stack underflow", "push 1\nadd\n", KindStackUnderflow, 9, 1, []int{}},
+		{"call stack overflow", ".limits 16 1024 3\n:f\n    call f\n", KindCallStackOverflow, 11, 0, []int{11, 11, 11}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewVM(load(t, test.source), WithOutput(io.Discard)).Run(context.Background())
+
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) {
+				t.Fatalf("got %v, want a VMError", err)
+			}
+
+			if vmErr.Kind != test.kind || vmErr.PC != test.pc || vmErr.StackDepth != test.depth || !slices.Equal(vmErr.Backtrace, test.backtrace) {
+				t.Errorf("got %s at %x with depth %d and backtrace %x, want %s at %x with depth %d and backtrace %x",
+					vmErr.Kind, vmErr.PC, vmErr.StackDepth, vmErr.Backtrace, test.kind, test.pc, test.depth, test.backtrace)
+			}
+
+			if vmErr.Resumable() {
+				t.Errorf("%s is resumable", vmErr.Kind)
+			}
+		})
+	}
+}
+
+// decodingRun runs v the way the VM did before code was decoded into ops,
+// decoding each instruction every time it is reached. It is the baseline for
+// BenchmarkRunSpeed.
+func decodingRun(v *VM, index []int) error {
+	o := &op{}
+
+	for !v.halted {
+		pc := v.ops[v.index].pc
+		*o = op{exec: (*VM).instEnd, pc: pc}
+
+		if pc < len(v.code) {
+			spec, _ := instructions.Lookup(v.code[pc])
+			o.exec = handlers[spec.Opcode]
+			regs := 0
+
+			at := pc + 1
+			for _, kind := range spec.Operands {
+				switch kind {
+				case instructions.OperandRegister:
+					if regs == 0 {
+						o.a = int(v.code[at])
+					} else {
+						o.b = int(v.code[at])
+					}
+					regs++
+				case instructions.OperandLiteral:
+					o.val = int64(binary.LittleEndian.Uint64(v.code[at:]))
+				case instructions.OperandAddress:
+					o.target = index[binary.LittleEndian.Uint32(v.code[at:])]
+				}
+
+				at += kind.Size()
+			}
+		}
+
+		v.inst = v.index
+		v.index++
+
+		if err := o.exec(v, o); err != nil {
+			return v.flush(err)
+		}
+	}
+
+	return v.flush(nil)
+}
+
+func BenchmarkRunSpeed(b *testing.B) {
+	source, err := os.ReadFile("../examples/speed.stop")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	program := load(b, string(source))
+	_, offsets := decodeOps(program.code)
+	index := make([]int, len(program.code)+1)
+	for pc, i := range offsets {
+		index[pc] = i
+	}
+
+	b.Run("decoding", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := decodingRun(NewVM(program, WithOutput(io.Discard)), index); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ops", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			v := NewVM(program, WithOutput(io.Discard))
+			v.fused = v.ops
+
+			if err := v.Run(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("fused", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := NewVM(program, WithOutput(io.Discard)).Run(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}